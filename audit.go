@@ -0,0 +1,169 @@
+package plainsecrets
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+func (e *entry) isEncrypted() bool {
+	return e.Encoding == Encrypted || e.Encoding == Envelope || e.Encoding == EncryptedFile
+}
+
+// recipientNames lists the recipient keys of an envelope, which are reported
+// to OnDecrypt when none of them could decrypt it.
+func (e *entry) recipientNames() string {
+	names := make([]string, len(e.Recipients))
+	for i, r := range e.Recipients {
+		names[i] = r.KeyName
+	}
+	return strings.Join(names, ",")
+}
+
+// entryValueBytes returns the value of the entry picked for name and env,
+// reporting decryptions to OnDecrypt.
+func (vals *Values) entryValueBytes(name, env string, e *entry, keyring KeyProvider) ([]byte, error) {
+	if e.Encoding == Encrypted || e.Encoding == Envelope {
+		val, keyName, err := e.unpaddedPlaintext(keyring)
+		vals.audit(name, env, e, keyName, err)
+		return val, err
+	}
+	val, err := e.ValueBytes(keyring)
+	if e.isEncrypted() {
+		vals.audit(name, env, e, e.KeyName, err)
+	}
+	return val, err
+}
+
+// audit reports a decryption of the entry (or of its envelope data key) by
+// the given key to OnDecrypt. The key name is empty if no key could decrypt
+// the value, in which case all the keys that could have are reported.
+func (vals *Values) audit(name, env string, e *entry, keyName string, err error) {
+	if vals.OnDecrypt == nil {
+		return
+	}
+	if keyName == "" {
+		keyName = e.KeyName
+		if e.Encoding == Envelope {
+			keyName = e.recipientNames()
+		}
+	}
+	vals.OnDecrypt(name, env, keyName, err)
+}
+
+func (vals *Values) entryValue(name, env string, e *entry, keyring KeyProvider) (string, error) {
+	b, err := vals.entryValueBytes(name, env, e, keyring)
+	str := string(b)
+	Wipe(b)
+	return str, err
+}
+
+// AuditLog writes a JSON line per decryption, e.g.
+//
+//	{"time":"2026-10-18T12:00:00Z","name":"DB_PASSWORD","env":"prod","key":"myapp-prod"}
+//
+// with an "error" field for failed decryptions. Use as
+// vals.OnDecrypt = plainsecrets.NewAuditLog(w).Record.
+type AuditLog struct {
+	// Clock returns the time recorded for each entry, defaults to time.Now.
+	Clock func() time.Time
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewAuditLog(w io.Writer) *AuditLog {
+	return &AuditLog{w: w}
+}
+
+type auditRecord struct {
+	Time  time.Time `json:"time"`
+	Name  string    `json:"name"`
+	Env   string    `json:"env"`
+	Key   string    `json:"key"`
+	Error string    `json:"error,omitempty"`
+}
+
+// Record writes an entry; it has the signature of Values.OnDecrypt. Write
+// errors are ignored so that auditing never breaks the app.
+func (al *AuditLog) Record(name, env, keyName string, err error) {
+	rec := auditRecord{Name: name, Env: env, Key: keyName}
+	if al.Clock != nil {
+		rec.Time = al.Clock()
+	} else {
+		rec.Time = time.Now().UTC()
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	line, _ := json.Marshal(rec)
+
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	al.w.Write(append(line, '\n'))
+}
+
+// EnvView gives access to the values of one env, decrypting each value on
+// first access, so that only the values an app actually uses get decrypted
+// (and show up in the OnDecrypt audit trail). See Values.EnvValues.
+//
+// Decrypted values are cached as strings for the lifetime of the view, so
+// they stay in memory until the view is garbage collected; use
+// Values.ValueBytes for secrets that need to be wiped. Its methods are safe
+// for concurrent use, as long as nothing else uses the Values at the same
+// time.
+type EnvView struct {
+	vals    *Values
+	env     string
+	keyring KeyProvider
+
+	mu     sync.Mutex
+	values map[string]string
+}
+
+// Get returns the value, decrypting it on first access. Errors are not
+// cached, so a failed value is retried on the next call.
+func (ev *EnvView) Get(name string) (string, error) {
+	ev.mu.Lock()
+	defer ev.mu.Unlock()
+	if val, ok := ev.values[name]; ok {
+		return val, nil
+	}
+	val, err := ev.vals.Value(name, ev.env, ev.keyring)
+	if err != nil {
+		return "", err
+	}
+	ev.values[name] = val
+	return val, nil
+}
+
+// Secret is like Get, but returns a Secret.
+func (ev *EnvView) Secret(name string) (Secret, error) {
+	val, err := ev.Get(name)
+	return Secret{val}, err
+}
+
+// All decrypts the values that haven't been accessed yet, and returns all
+// non-empty values. Returns the last error, if any, along with the values
+// that could be decrypted.
+func (ev *EnvView) All() (map[string]string, error) {
+	result := make(map[string]string)
+	var lastErr error
+	for _, name := range ev.Names() {
+		val, err := ev.Get(name)
+		if err != nil {
+			lastErr = err
+		} else if val != "" {
+			result[name] = val
+		}
+	}
+	return result, lastErr
+}
+
+// Names returns the names of all values, including the ones without a value
+// for this env.
+func (ev *EnvView) Names() []string {
+	return ev.vals.Names()
+}
@@ -0,0 +1,87 @@
+package plainsecrets
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditLog(t *testing.T) {
+	keyring := must(ParseKeyringString(sampleKeyring))
+	enc := func(s, keyName string) string {
+		return must(New().EncryptValue(s, "prod", keyName, append(Keyring{NewBoxKey("ops")}, keyring...)))
+	}
+	vals := must(ParseString("@all = prod\nURL = http://example.com\nA = " + enc("a", "myapp-prod") + "\nB = " + enc("b", "myapp-prod") + "\nE = " + enc("e", "ops,myapp-prod")))
+
+	var buf bytes.Buffer
+	audit := NewAuditLog(&buf)
+	audit.Clock = func() time.Time { return time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC) }
+	vals.OnDecrypt = audit.Record
+
+	if _, err := vals.EnvValues("staging", keyring); err == nil {
+		t.Errorf("** got a view of an unknown env")
+	}
+	env := must(vals.EnvValues("prod", keyring))
+	for i := 0; i < 2; i++ {
+		if a, e := must(env.Get("A")), "a"; a != e {
+			t.Errorf("** got %q, wanted %q", a, e)
+		}
+	}
+	if a, e := must(env.Get("URL")), "http://example.com"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+	if a, e := must(env.Get("E")), "e"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+	if _, err := vals.Value("B", "prod", Keyring{}); err == nil {
+		t.Errorf("** decrypted without keys")
+	}
+	if _, err := vals.Value("E", "prod", Keyring{}); err == nil {
+		t.Errorf("** decrypted without keys")
+	}
+
+	expected := `{"time":"2026-10-18T12:00:00Z","name":"A","env":"prod","key":"myapp-prod"}
+{"time":"2026-10-18T12:00:00Z","name":"E","env":"prod","key":"myapp-prod"}
+{"time":"2026-10-18T12:00:00Z","name":"B","env":"prod","key":"myapp-prod","error":"missing key myapp-prod"}
+{"time":"2026-10-18T12:00:00Z","name":"E","env":"prod","key":"ops,myapp-prod","error":"missing all of recipient keys ops, myapp-prod"}
+`
+	if a := buf.String(); a != expected {
+		t.Errorf("** got:\n%s\nwanted:\n%s", a, expected)
+	}
+	if a, e := strings.Join(env.Names(), " "), "A B E URL"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+
+	// All decrypts the rest
+	buf.Reset()
+	if a, e := len(must(env.All())), 4; a != e {
+		t.Errorf("** got %d values, wanted %d", a, e)
+	}
+	if a, e := buf.String(), `{"time":"2026-10-18T12:00:00Z","name":"B","env":"prod","key":"myapp-prod"}
+`; a != e {
+		t.Errorf("** got:\n%s\nwanted:\n%s", a, e)
+	}
+}
+
+func TestAuditMaintenance(t *testing.T) {
+	keyring := must(ParseKeyringString(sampleKeyring))
+	keyring.Add(NewBoxKey("ops"))
+	input := "@all = prod\nA.prod = " + must(New().EncryptValue("a", "prod", "myapp-prod", keyring)) + "\nE = " + must(New().EncryptValue("e", "prod", "myapp-prod,myapp-dev", keyring)) + "\n"
+	vals := must(ParseString(input))
+	var records []string
+	vals.OnDecrypt = func(name, env, keyName string, err error) {
+		records = append(records, name+"."+env+" "+keyName)
+	}
+
+	vals.Padding = 16
+	if _, n, failed := vals.RepadAllInString(input, keyring); n != 2 || len(failed) > 0 {
+		t.Fatalf("** repadded %d, failed %v", n, failed)
+	}
+	if _, n, err := vals.AddRecipientInString(input, nil, "ops", keyring); n != 1 || err != nil {
+		t.Fatalf("** added %d recipients, err %v", n, err)
+	}
+	if a, e := strings.Join(records, " | "), "A.prod myapp-prod | E.all myapp-prod | E.all myapp-prod"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+}
@@ -0,0 +1,217 @@
+package plainsecrets
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A key backup is meant to be printed and typed back in by a human:
+//
+//	# plainsecrets key backup
+//	name: myapp-prod
+//	type: symmetric
+//	fingerprint: a1b2
+//	1: VQ2KA GBCSV 4B7YF DQU2L
+//	2: ...
+//
+// The key data is base32-encoded and split into groups of 4 characters, each
+// followed by a check character, so that a typo can be pinpointed to a group.
+const (
+	backupHeader      = "# plainsecrets key backup"
+	backupGroupLen    = 4
+	backupGroupsPerLn = 4
+	backupAlphabet    = "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567"
+)
+
+var backupEncoding = base32.NewEncoding(backupAlphabet).WithPadding(base32.NoPadding)
+
+// Backup encodes the key for printing on paper.
+func (key *Key) Backup() (string, error) {
+	secret, err := key.secret()
+	if err != nil {
+		return "", err
+	}
+	encoded := backupEncoding.EncodeToString(secret[:])
+
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "%s\nname: %s\ntype: %s\nfingerprint: %s\n", backupHeader, key.Name, key.Type, key.Fingerprint())
+	for i := 0; i*backupGroupLen < len(encoded); i++ {
+		if i%backupGroupsPerLn == 0 {
+			if i > 0 {
+				buf.WriteByte('\n')
+			}
+			fmt.Fprintf(&buf, "%d:", i/backupGroupsPerLn+1)
+		}
+		group := encoded[i*backupGroupLen:]
+		if len(group) > backupGroupLen {
+			group = group[:backupGroupLen]
+		}
+		buf.WriteByte(' ')
+		buf.WriteString(group)
+		buf.WriteByte(backupCheckChar(i, group))
+	}
+	buf.WriteByte('\n')
+	return buf.String(), nil
+}
+
+func backupCheckChar(index int, group string) byte {
+	h := sha256.Sum256([]byte("plainsecrets backup " + strconv.Itoa(index) + " " + group))
+	return backupAlphabet[h[0]%32]
+}
+
+// RestoreKeyBackup decodes a key produced by Backup. Characters that are easy
+// to confuse (0/O, 1/I, 8/B) and lowercase letters are accepted. When a group
+// fails its check, the error suggests corrections.
+func RestoreKeyBackup(text string) (*Key, error) {
+	key := &Key{}
+	var fingerprint string
+	var groups []string
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		k, v, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid backup line %q", line)
+		}
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		switch k {
+		case "name":
+			if !IsValidKeyName(v) {
+				return nil, fmt.Errorf("invalid key name %q", v)
+			}
+			key.Name = v
+		case "type":
+			var err error
+			key.Type, err = ParseKeyType(v)
+			if err != nil {
+				return nil, err
+			}
+		case "fingerprint":
+			fingerprint = v
+		default:
+			if _, err := strconv.Atoi(k); err != nil {
+				return nil, fmt.Errorf("invalid backup line %q", line)
+			}
+			groups = append(groups, strings.Fields(v)...)
+		}
+	}
+	if key.Name == "" {
+		return nil, fmt.Errorf("missing key name")
+	}
+	if fingerprint == "" {
+		return nil, fmt.Errorf("missing fingerprint")
+	}
+
+	wantLen := backupEncoding.EncodedLen(KeySize)
+	wantGroups := (wantLen + backupGroupLen - 1) / backupGroupLen
+	if len(groups) != wantGroups {
+		return nil, fmt.Errorf("got %d groups, wanted %d", len(groups), wantGroups)
+	}
+
+	var bad []int
+	for i, g := range groups {
+		groups[i] = normalizeBackupGroup(g)
+		if !isValidBackupGroup(i, groups[i]) {
+			bad = append(bad, i)
+		}
+	}
+	if len(bad) > 0 {
+		var errs []error
+		for _, i := range bad {
+			suggestions := backupSuggestions(i, groups[i])
+			if len(bad) == 1 {
+				// with a single typo, the fingerprint tells which correction is right
+				suggestions = filter(suggestions, func(s string) bool {
+					fixed := append([]string(nil), groups...)
+					fixed[i] = s
+					k, err := decodeBackupGroups(key, fixed)
+					return err == nil && k.Fingerprint() == fingerprint
+				})
+			}
+			errs = append(errs, backupGroupError(i, groups[i], suggestions))
+		}
+		return nil, errors.Join(errs...)
+	}
+
+	key, err := decodeBackupGroups(key, groups)
+	if err != nil {
+		return nil, err
+	}
+	if fp := key.Fingerprint(); fp != fingerprint {
+		return nil, fmt.Errorf("fingerprint mismatch, restored key is %s#%s, wanted #%s", key.Name, fp, fingerprint)
+	}
+	return key, nil
+}
+
+func decodeBackupGroups(key *Key, groups []string) (*Key, error) {
+	var encoded strings.Builder
+	for _, g := range groups {
+		encoded.WriteString(g[:backupGroupLen])
+	}
+	raw, err := backupEncoding.DecodeString(encoded.String())
+	if err != nil || len(raw) != KeySize {
+		return nil, fmt.Errorf("invalid key data")
+	}
+	k := *key
+	copy(k.Data[:], raw)
+	return &k, nil
+}
+
+func isValidBackupGroup(index int, g string) bool {
+	return len(g) == backupGroupLen+1 && strings.Trim(g, backupAlphabet) == "" && backupCheckChar(index, g[:backupGroupLen]) == g[backupGroupLen]
+}
+
+func normalizeBackupGroup(g string) string {
+	return strings.NewReplacer("0", "O", "1", "I", "8", "B").Replace(strings.ToUpper(g))
+}
+
+// backupSuggestions returns valid groups that differ from g by a single
+// character or a swap of adjacent characters.
+func backupSuggestions(index int, g string) []string {
+	if len(g) != backupGroupLen+1 {
+		return nil
+	}
+	var suggestions []string
+	try := func(s string) {
+		if isValidBackupGroup(index, s) && !contains(suggestions, s) {
+			suggestions = append(suggestions, s)
+		}
+	}
+	for i := range g {
+		for _, c := range []byte(backupAlphabet) {
+			try(g[:i] + string(c) + g[i+1:])
+		}
+		if i+1 < len(g) {
+			try(g[:i] + g[i+1:i+2] + g[i:i+1] + g[i+2:])
+		}
+	}
+	return suggestions
+}
+
+func backupGroupError(index int, g string, suggestions []string) error {
+	line, pos := index/backupGroupsPerLn+1, index%backupGroupsPerLn+1
+	if len(g) != backupGroupLen+1 {
+		return fmt.Errorf("line %d group %d %q: must be %d characters", line, pos, g, backupGroupLen+1)
+	} else if len(suggestions) == 0 {
+		return fmt.Errorf("line %d group %d %q: typo", line, pos, g)
+	}
+	return fmt.Errorf("line %d group %d %q: typo, did you mean %s?", line, pos, g, strings.Join(suggestions, " or "))
+}
+
+func filter(list []string, f func(string) bool) []string {
+	var result []string
+	for _, item := range list {
+		if f(item) {
+			result = append(result, item)
+		}
+	}
+	return result
+}
@@ -0,0 +1,41 @@
+package plainsecrets
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestKeyBackup(t *testing.T) {
+	key := must(ParseKeyringString(sampleKeyring)).ByName("myapp-prod")
+	backup := must(key.Backup())
+	if a, e := strings.Count(backup, "\n"), 8; a != e {
+		t.Errorf("** got %d lines, wanted %d:\n%s", a, e, backup)
+	}
+
+	restored, err := RestoreKeyBackup(strings.ToLower(backup))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Name != "myapp-prod" || restored.Data != key.Data {
+		t.Errorf("** restored %s, wanted %s", restored.Ref(), key.Ref())
+	}
+
+	lines := strings.Split(backup, "\n")
+	groups := strings.Fields(lines[5])
+	good := groups[2]
+	bad := good[:1] + string(backupAlphabet[(strings.IndexByte(backupAlphabet, good[1])+1)%32]) + good[2:]
+	_, err = RestoreKeyBackup(strings.Replace(backup, good, bad, 1))
+	if a, e := tostr3("", err), `ERR: line 2 group 2 "`+bad+`": typo, did you mean `+good+`?`; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+
+	_, err = RestoreKeyBackup(strings.Replace(backup, "fingerprint: "+key.Fingerprint(), "fingerprint: 0000", 1))
+	if a, e := tostr3("", err), "ERR: fingerprint mismatch, restored key is myapp-prod#"+key.Fingerprint()+", wanted #0000"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+
+	box := NewBoxKey("alice")
+	if restored := must(RestoreKeyBackup(must(box.Backup()))); restored.Type != BoxPrivateKey || restored.Data != box.Data {
+		t.Errorf("** box key not restored")
+	}
+}
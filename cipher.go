@@ -0,0 +1,93 @@
+package plainsecrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// Cipher is the authenticated encryption algorithm used with a symmetric key.
+type Cipher int
+
+const (
+	// SecretBox is NaCl secretbox (XSalsa20-Poly1305), the default.
+	SecretBox = Cipher(iota)
+	// AES256GCM is AES-256 in GCM mode with a random 96-bit nonce.
+	AES256GCM
+	// XChaCha20Poly1305 is XChaCha20-Poly1305 (IETF AEAD construction).
+	XChaCha20Poly1305
+)
+
+var cipherNames = []string{"secretbox", "aes-256-gcm", "xchacha20-poly1305"}
+
+func (c Cipher) String() string {
+	if c < 0 || int(c) >= len(cipherNames) {
+		return fmt.Sprintf("Cipher(%d)", int(c))
+	}
+	return cipherNames[c]
+}
+
+func ParseCipher(s string) (Cipher, error) {
+	for i, name := range cipherNames {
+		if s == name {
+			return Cipher(i), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid cipher %q", s)
+}
+
+func (c Cipher) NonceSize() int {
+	switch c {
+	case AES256GCM:
+		return 12
+	case XChaCha20Poly1305:
+		return chacha20poly1305.NonceSizeX
+	default:
+		return NonceSize
+	}
+}
+
+func (c Cipher) aead(key *[KeySize]byte) cipher.AEAD {
+	var aead cipher.AEAD
+	var err error
+	switch c {
+	case AES256GCM:
+		var block cipher.Block
+		block, err = aes.NewCipher(key[:])
+		if err == nil {
+			aead, err = cipher.NewGCM(block)
+		}
+	case XChaCha20Poly1305:
+		aead, err = chacha20poly1305.NewX(key[:])
+	default:
+		panic("unreachable")
+	}
+	if err != nil {
+		panic(err) // only fails on wrong key size
+	}
+	return aead
+}
+
+func (c Cipher) seal(key *[KeySize]byte, plaintext []byte) (nonce, ciphertext []byte, err error) {
+	nonce = make([]byte, c.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	if c == SecretBox {
+		return nonce, secretbox.Seal(nil, plaintext, (*[NonceSize]byte)(nonce), key), nil
+	}
+	return nonce, c.aead(key).Seal(nil, nonce, plaintext, nil), nil
+}
+
+func (c Cipher) open(key *[KeySize]byte, nonce, ciphertext []byte) ([]byte, bool) {
+	if c == SecretBox {
+		return secretbox.Open(nil, ciphertext, (*[NonceSize]byte)(nonce), key)
+	}
+	plaintext, err := c.aead(key).Open(nil, nonce, ciphertext, nil)
+	return plaintext, err == nil
+}
@@ -0,0 +1,90 @@
+package plainsecrets
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCiphers(t *testing.T) {
+	input := "# plainsecrets keyring v2\n\n[gcm]\ntype = symmetric\ncipher = aes-256-gcm\ndata = rTYS3+vPf0XfCPW4tCykpQoqcxMyiciNLaDlj+VSuQU=\n\n[xchacha]\ntype = symmetric\ncipher = xchacha20-poly1305\ndata = 5OnO+jqOo/hhz1DVJox3TpaefmbwFqbiw6HYfuogz+Y=\n\n[nacl]\ntype = symmetric\ndata = 5OnO+jqOo/hhz1DVJox3TpaefmbwFqbiw6HYfuogz+Y=\n"
+	keyring := must(ParseKeyringString(input))
+	if a, e := keyring.Data(), input; a != e {
+		t.Errorf("** Data() = %q, wanted %q", a, e)
+	}
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "cert.pem"), []byte("CERT"), 0600)
+	vals := New()
+	vals.dir = dir
+	if err := vals.ParseString("@all = dev"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct{ key, prefix string }{
+		{"gcm", "secret:v2:aes-256-gcm:gcm:"},
+		{"xchacha", "secret:v2:xchacha20-poly1305:xchacha:"},
+		{"nacl", "secret:nacl:"},
+	} {
+		rhs := must(vals.EncryptValue("hello", "dev", c.key, keyring))
+		if !strings.HasPrefix(rhs, c.prefix) {
+			t.Errorf("** %s: got %q, wanted prefix %q", c.key, rhs, c.prefix)
+		}
+		file := must(vals.EncryptFile("cert.pem", "dev", c.key, keyring))
+		recipients := c.key + ",gcm"
+		if c.key == "gcm" {
+			recipients = "gcm,nacl"
+		}
+		env := must(vals.EncryptValue("hi", "dev", recipients, keyring))
+
+		v := New()
+		v.dir = dir
+		if err := v.ParseString("@all = dev\nA = " + rhs + "\nF = " + file + "\nE = " + env); err != nil {
+			t.Fatal(err)
+		}
+		only := Keyring{keyring.ByName(c.key)}
+		if a, e := tostr3(v.Value("A", "dev", only)), "hello"; a != e {
+			t.Errorf("** %s: got %q, wanted %q", c.key, a, e)
+		}
+		if a, e := tostr3(v.Value("F", "dev", only)), "CERT"; a != e {
+			t.Errorf("** %s: file got %q, wanted %q", c.key, a, e)
+		}
+		if a, e := tostr3(v.Value("E", "dev", only)), "hi"; a != e {
+			t.Errorf("** %s: envelope got %q, wanted %q", c.key, a, e)
+		}
+
+		// the cipher is taken from the value, not from the key
+		other := *only[0]
+		other.Cipher = (other.Cipher + 1) % 3
+		if a, e := tostr3(v.Value("A", "dev", Keyring{&other})), "hello"; a != e {
+			t.Errorf("** %s: after cipher change got %q, wanted %q", c.key, a, e)
+		}
+	}
+
+	rhs := must(vals.EncryptValue("hello", "dev", "gcm", keyring))
+	renamed, n, err := RenameKeyInString("A = "+rhs+"\n", "gcm", "aes")
+	if err != nil || n != 1 || !strings.HasPrefix(renamed, "A = secret:v2:aes-256-gcm:aes:") {
+		t.Errorf("** renamed %d %v: %q", n, err, renamed)
+	}
+
+	if _, err := ParseKeyringString("# plainsecrets keyring v2\n[a]\ntype = box-private\ncipher = aes-256-gcm\ndata = 5OnO+jqOo/hhz1DVJox3TpaefmbwFqbiw6HYfuogz+Y=\n"); err == nil {
+		t.Errorf("** accepted a cipher for a box key")
+	}
+}
+
+func TestCipherString(t *testing.T) {
+	for _, tt := range []struct {
+		c Cipher
+		e string
+	}{
+		{SecretBox, "secretbox"},
+		{XChaCha20Poly1305, "xchacha20-poly1305"},
+		{Cipher(7), "Cipher(7)"},
+		{Cipher(-1), "Cipher(-1)"},
+	} {
+		if a := tt.c.String(); a != tt.e {
+			t.Errorf("** got %q, wanted %q", a, tt.e)
+		}
+	}
+}
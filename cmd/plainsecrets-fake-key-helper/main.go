@@ -0,0 +1,40 @@
+// Command plainsecrets-fake-key-helper is a key helper for testing
+// HelperKeyProvider setups. It serves keys from a plain keyring file.
+//
+//	plainsecrets -Khelper "plainsecrets-fake-key-helper -K testdata/keyring.txt" -f secrets.txt '*'
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"github.com/andreyvit/plainsecrets"
+)
+
+func main() {
+	log.SetFlags(0)
+
+	var keyringFile string
+	var delay time.Duration
+	var fail string
+	flag.StringVar(&keyringFile, "K", os.Getenv("PLAINSECRETS_FAKE_KEYRING"), "path to keyring file to serve (defaults to $PLAINSECRETS_FAKE_KEYRING)")
+	flag.DurationVar(&delay, "delay", 0, "sleep before responding, to test timeouts")
+	flag.StringVar(&fail, "fail", "", "fail with this error message")
+	flag.Parse()
+
+	time.Sleep(delay)
+	if fail != "" {
+		log.Fatal(fail)
+	}
+
+	keyring, err := plainsecrets.ParseKeyringFile(keyringFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = plainsecrets.ServeKeyHelper(flag.Args(), os.Stdin, os.Stdout, keyring)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
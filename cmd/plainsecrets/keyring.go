@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/andreyvit/plainsecrets"
+)
+
+const newPassphraseEnvVar = "PLAINSECRETS_NEW_PASSPHRASE"
+
+// passphrase entered interactively, remembered to re-encrypt the keyring on save
+var knownPassphrase string
+
+func promptPassphrase(what string) (string, error) {
+	if knownPassphrase != "" {
+		return knownPassphrase, nil
+	}
+	p, err := readPassphrase(fmt.Sprintf("Passphrase for %s: ", what))
+	if err != nil {
+		return "", err
+	}
+	knownPassphrase = p
+	return p, nil
+}
+
+func promptSSHPassphrase(path string) (string, error) {
+	return readPassphrase(fmt.Sprintf("Passphrase for %s: ", path))
+}
+
+// readPassphrase reads a line from the terminal with echo disabled, or from
+// stdin if there is no terminal.
+func readPassphrase(prompt string) (string, error) {
+	in := os.Stdin
+	if tty, err := os.Open("/dev/tty"); err == nil {
+		defer tty.Close()
+		in = tty
+		stty := func(arg string) {
+			cmd := exec.Command("stty", arg)
+			cmd.Stdin = tty
+			cmd.Run()
+		}
+		stty("-echo")
+		defer stty("echo")
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	line, err := bufio.NewReader(in).ReadString('\n')
+	fmt.Fprintln(os.Stderr)
+	if err != nil && line == "" {
+		return "", fmt.Errorf("cannot read passphrase: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func readNewPassphrase() string {
+	if v := os.Getenv(newPassphraseEnvVar); v != "" {
+		return v
+	}
+	p1, err := readPassphrase("New passphrase: ")
+	ensure(err)
+	p2, err := readPassphrase("Repeat new passphrase: ")
+	ensure(err)
+	if p1 != p2 {
+		log.Fatalf("*** passphrases do not match.")
+	}
+	if p1 == "" {
+		log.Fatalf("*** empty passphrase.")
+	}
+	return p1
+}
+
+// saveKeyring writes the keyring, keeping it encrypted and/or in v2 format if it was.
+func saveKeyring(path string, keyring plainsecrets.Keyring) {
+	data := keyring.Data()
+	if raw, err := os.ReadFile(path); err == nil && plainsecrets.IsEncryptedKeyring(string(raw)) {
+		passphrase, err := plainsecrets.Passphrase(path)
+		ensure(err)
+		data, err = keyring.EncryptedData(passphrase)
+		ensure(err)
+	} else if err == nil && plainsecrets.IsKeyringV2(string(raw)) {
+		data = keyring.DataV2()
+	}
+	ensure(os.WriteFile(path, []byte(data), 0600))
+}
+
+const keysUsage = "*** usage: plainsecrets keys list|remove NAME|rename OLD NEW|export [-o FILE] NAME...|import FILE [NAME...]|backup NAME|restore [FILE]|split [-n N] [-k K] NAME|combine [FILE...]|upgrade"
+
+// keysCommand handles keyring management subcommands. If a secrets file is
+// given, list shows which values use each key, and rename updates references.
+func keysCommand(keyringFile, secretsFile string, keyring plainsecrets.Keyring, args []string) {
+	if len(args) == 0 {
+		log.Fatalf(keysUsage)
+	}
+	if keyringFile == "" {
+		log.Fatalf("*** keys %s requires -K or -KV.", args[0])
+	}
+	switch args[0] {
+	case "list":
+		usage := keyUsage(secretsFile)
+		for _, k := range keyring {
+			scope := strings.Join(k.Scope, " ")
+			if scope == "" {
+				scope = "*"
+			}
+			typ := k.Type.String()
+			if k.DeriveFrom != "" {
+				typ += " derive:" + k.DeriveFrom + ":" + k.DerivePath
+			}
+			line := fmt.Sprintf("%s\t%s\t%s\t%s\t%s", k.Ref(), typ, scope, k.Description, strings.Join(usage[k.Name], " "))
+			fmt.Println(strings.TrimRight(line, "\t"))
+		}
+		for _, name := range sortedNames(usage) {
+			if keyring.ByName(name) == nil {
+				fmt.Printf("%s\t(not in keyring)\t\t\t%s\n", name, strings.Join(usage[name], " "))
+			}
+		}
+	case "remove":
+		if len(args) != 2 {
+			log.Fatalf(keysUsage)
+		}
+		if !keyring.Remove(args[1]) {
+			log.Fatalf("*** key %s not found.", args[1])
+		}
+		saveKeyring(keyringFile, keyring)
+		log.Printf("removed %s.", args[1])
+		if uses := keyUsage(secretsFile)[args[1]]; len(uses) > 0 {
+			log.Printf("** warning: %s still uses it: %s", secretsFile, strings.Join(uses, " "))
+		}
+	case "rename":
+		if len(args) != 3 {
+			log.Fatalf(keysUsage)
+		}
+		ensure(keyring.Rename(args[1], args[2]))
+		saveKeyring(keyringFile, keyring)
+		log.Printf("renamed %s to %s.", args[1], args[2])
+		if secretsFile != "" {
+			n, err := plainsecrets.RenameKeyInFile(secretsFile, args[1], args[2])
+			ensure(err)
+			log.Printf("updated %d values in %s.", n, secretsFile)
+		}
+	case "export":
+		fs := flag.NewFlagSet("keys export", flag.ExitOnError)
+		output := fs.String("o", "", "keyring file to add the keys to, instead of printing them")
+		fs.Parse(args[1:])
+		if fs.NArg() == 0 {
+			log.Fatalf(keysUsage)
+		}
+		keys := selectKeys(keyring, fs.Args())
+		if *output == "" {
+			fmt.Print(keys.Data())
+			return
+		}
+		withOtherKeyring(*output, true, func(other *plainsecrets.Keyring) {
+			n, err := other.Import(keys)
+			ensure(err)
+			saveKeyring(*output, *other)
+			log.Printf("exported %d keys to %s.", n, *output)
+		})
+	case "import":
+		if len(args) < 2 {
+			log.Fatalf(keysUsage)
+		}
+		var keys plainsecrets.Keyring
+		withOtherKeyring(args[1], false, func(other *plainsecrets.Keyring) {
+			keys = *other
+		})
+		if len(args) > 2 {
+			keys = selectKeys(keys, args[2:])
+		}
+		n, err := keyring.Import(keys)
+		ensure(err)
+		saveKeyring(keyringFile, keyring)
+		log.Printf("imported %d keys.", n)
+	case "backup":
+		if len(args) != 2 {
+			log.Fatalf(keysUsage)
+		}
+		k := keyring.ByName(args[1])
+		if k == nil {
+			log.Fatalf("*** key %s not found.", args[1])
+		}
+		backup, err := k.Backup()
+		ensure(err)
+		fmt.Print(backup)
+	case "restore":
+		var raw []byte
+		var err error
+		if len(args) > 2 {
+			log.Fatalf(keysUsage)
+		} else if len(args) == 2 {
+			raw, err = os.ReadFile(args[1])
+		} else {
+			raw, err = io.ReadAll(os.Stdin)
+		}
+		ensure(err)
+		k, err := plainsecrets.RestoreKeyBackup(string(raw))
+		ensure(err)
+		n, err := keyring.Import(plainsecrets.Keyring{k})
+		ensure(err)
+		if n == 0 {
+			log.Printf("%s is already in the keyring.", k.Ref())
+			return
+		}
+		saveKeyring(keyringFile, keyring)
+		log.Printf("restored %s.", k.Ref())
+	case "split":
+		fs := flag.NewFlagSet("keys split", flag.ExitOnError)
+		n := fs.Int("n", 5, "number of shares")
+		k := fs.Int("k", 3, "number of shares needed to reconstruct the key")
+		fs.Parse(args[1:])
+		if fs.NArg() != 1 {
+			log.Fatalf(keysUsage)
+		}
+		key := keyring.ByName(fs.Arg(0))
+		if key == nil {
+			log.Fatalf("*** key %s not found.", fs.Arg(0))
+		}
+		shares, err := plainsecrets.SplitKey(key, *n, *k)
+		ensure(err)
+		for _, share := range shares {
+			fmt.Println(share)
+		}
+	case "combine":
+		var shares []string
+		readShares := func(r io.Reader) {
+			scanner := bufio.NewScanner(r)
+			for scanner.Scan() {
+				if plainsecrets.IsKeyShare(scanner.Text()) {
+					shares = append(shares, scanner.Text())
+				}
+			}
+			ensure(scanner.Err())
+		}
+		if len(args) == 1 {
+			readShares(os.Stdin)
+		}
+		for _, fn := range args[1:] {
+			f, err := os.Open(fn)
+			ensure(err)
+			readShares(f)
+			f.Close()
+		}
+		k, err := plainsecrets.CombineKeyShares(shares)
+		ensure(err)
+		n, err := keyring.Import(plainsecrets.Keyring{k})
+		ensure(err)
+		if n == 0 {
+			log.Printf("%s is already in the keyring.", k.Ref())
+			return
+		}
+		saveKeyring(keyringFile, keyring)
+		log.Printf("combined %s.", k.Ref())
+	case "upgrade":
+		raw, err := os.ReadFile(keyringFile)
+		ensure(err)
+		data := string(raw)
+		var passphrase string
+		if plainsecrets.IsEncryptedKeyring(data) {
+			// an encrypted keyring may wrap v1 data
+			passphrase, err = plainsecrets.Passphrase(keyringFile)
+			ensure(err)
+			data, err = plainsecrets.DecryptKeyringString(data, passphrase)
+			ensure(err)
+		}
+		if plainsecrets.IsKeyringV2(data) {
+			log.Printf("already in v2 format.")
+			return
+		}
+		data = keyring.DataV2()
+		if passphrase != "" {
+			data, err = keyring.EncryptedData(passphrase) // encrypts v2 data
+			ensure(err)
+		}
+		ensure(os.WriteFile(keyringFile, []byte(data), 0600))
+		log.Printf("upgraded to v2 format.")
+	default:
+		log.Fatalf("*** unknown command: keys %s", args[0])
+	}
+}
+
+func keyUsage(secretsFile string) map[string][]string {
+	if secretsFile == "" {
+		return nil
+	}
+	vals, err := plainsecrets.ParseFile(secretsFile)
+	ensure(err)
+	return vals.KeyUsage()
+}
+
+func selectKeys(keyring plainsecrets.Keyring, names []string) plainsecrets.Keyring {
+	var result plainsecrets.Keyring
+	for _, name := range names {
+		k := keyring.ByName(name)
+		if k == nil {
+			log.Fatalf("*** key %s not found.", name)
+		}
+		result = append(result, k)
+	}
+	return result
+}
+
+// withOtherKeyring loads another keyring file, which may have a different
+// passphrase than the main one.
+func withOtherKeyring(path string, create bool, f func(keyring *plainsecrets.Keyring)) {
+	saved := knownPassphrase
+	knownPassphrase = ""
+	defer func() { knownPassphrase = saved }()
+
+	keyring, err := plainsecrets.ParseKeyringFile(path)
+	if err != nil && os.IsNotExist(err) && create {
+		err = nil
+	}
+	ensure(err)
+	f(&keyring)
+}
+
+func sortedNames(m map[string][]string) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func changePassphrase(keyringFile string, keyring plainsecrets.Keyring, args []string) {
+	fs := flag.NewFlagSet("passwd", flag.ExitOnError)
+	remove := fs.Bool("remove", false, "remove the passphrase, storing keys in plain text")
+	fs.Parse(args)
+	if keyringFile == "" {
+		log.Fatalf("*** passwd requires -K or -KV.")
+	}
+
+	data := keyring.Data()
+	if !*remove {
+		var err error
+		data, err = keyring.EncryptedData(readNewPassphrase())
+		ensure(err)
+	}
+	ensure(os.WriteFile(keyringFile, []byte(data), 0600))
+	if *remove {
+		log.Printf("passphrase removed.")
+	} else {
+		log.Printf("passphrase set.")
+	}
+}
@@ -3,9 +3,13 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/andreyvit/plainsecrets"
 )
@@ -15,56 +19,149 @@ func main() {
 
 	var keyringFile string
 	var keyringEnv string
+	var keyDir string
+	var keyFD int
+	var keyHelper string
 	var secretsFile string
 	var secretsEnv string
 	var addKey string
+	var addKeyType string
+	var addKeyScope string
+	var addKeyDesc string
+	var addKeyDerive string
+	var addKeySSH string
+	var addKeyCipher string
 	var key string
 	var env string
+	var fingerprints bool
 	flag.StringVar(&keyringFile, "K", "", "path to keyring file (alternative to -KV)")
 	flag.StringVar(&keyringEnv, "KV", "", "env var with path to keyring file (alternative to -K)")
+	flag.StringVar(&keyDir, "Kdir", "", "directory with one file per key (in addition to keyring file)")
+	flag.IntVar(&keyFD, "Kfd", -1, "file descriptor to read keyring from, 0 for stdin (in addition to keyring file)")
+	flag.StringVar(&keyHelper, "Khelper", "", "key helper command to fetch keys from (in addition to keyring file)")
 	flag.StringVar(&secretsFile, "f", "", "path to secrets file (alternative to -fv)")
 	flag.StringVar(&secretsEnv, "fv", "", "env var with path to secrets file (alternative to -f)")
 	flag.StringVar(&addKey, "addkey", "", "generate a key and add to keyring under this name")
+	flag.StringVar(&addKeyType, "keytype", "symmetric", "type of key generated by -addkey: symmetric or box-private")
+	flag.StringVar(&addKeyScope, "scope", "", "space-separated envs and env groups the key generated by -addkey may encrypt for")
+	flag.StringVar(&addKeyDesc, "desc", "", "description of the key generated by -addkey")
+	flag.StringVar(&addKeyDerive, "derive", "", "make -addkey derive the key from a master key, as master:path (e.g. master:myapp/prod)")
+	flag.StringVar(&addKeySSH, "ssh", "", "make -addkey use this ssh-ed25519 public key file (e.g. ~/.ssh/id_ed25519.pub), decrypting with the private key file next to it")
+	flag.StringVar(&addKeyCipher, "cipher", "", "cipher used by the symmetric key generated by -addkey: secretbox (default), aes-256-gcm or xchacha20-poly1305")
 	flag.StringVar(&key, "k", "", "use key with this name for encrypting secrets")
 	flag.StringVar(&env, "e", "", "environment to get/set for")
+	flag.BoolVar(&fingerprints, "fingerprint", false, "record key fingerprints in newly encrypted values")
 	flag.Parse()
 
-	if keyringFile == "" {
-		if keyringEnv == "" {
-			log.Fatalf("*** either -K or -E must be specified.")
-		}
+	plainsecrets.PassphrasePrompt = promptPassphrase
+	plainsecrets.SSHPassphrasePrompt = promptSSHPassphrase
+
+	if keyringFile == "" && keyringEnv != "" {
 		keyringFile = os.Getenv(keyringEnv)
 		if keyringFile == "" {
 			log.Fatalf("*** missing environment variable %s.", keyringEnv)
 		}
 	}
-
-	keyring, err := plainsecrets.ParseKeyringFile(keyringFile)
-	if err != nil && os.IsNotExist(err) && addKey != "" {
-		err = nil
-	}
-	if err != nil {
-		ensure(fmt.Errorf("cannot read keyring: %w", err))
+	if keyringFile == "" && addKey != "" {
+		log.Fatalf("*** -addkey requires -K or -KV.")
 	}
+	creating := addKey != "" || (flag.Arg(0) == "keys" && (flag.Arg(1) == "import" || flag.Arg(1) == "restore" || flag.Arg(1) == "combine"))
 
-	if addKey != "" {
-		keyring.Add(plainsecrets.NewKey(addKey))
-		ensure(os.WriteFile(keyringFile, []byte(keyring.Data()), 0600))
+	var keyring plainsecrets.Keyring
+	var err error
+	if keyringFile != "" {
+		keyring, err = plainsecrets.ParseKeyringFile(keyringFile)
+		if err != nil && os.IsNotExist(err) && creating {
+			err = nil
+		}
+		if err != nil {
+			ensure(fmt.Errorf("cannot read keyring: %w", err))
+		}
 	}
 
-	if secretsFile == "" {
-		if secretsEnv == "" {
-			if addKey != "" {
-				return
+	if addKey != "" {
+		if keyring.ByName(addKey) != nil {
+			log.Fatalf("*** key %s already exists.", addKey)
+		}
+		var k *plainsecrets.Key
+		switch {
+		case addKeyDerive != "":
+			masterName, path, _ := strings.Cut(addKeyDerive, ":")
+			master := keyring.ByName(masterName)
+			if master == nil {
+				log.Fatalf("*** key %s not found.", masterName)
+			}
+			k, err = plainsecrets.NewDerivedKey(addKey, master, path)
+			ensure(err)
+		case addKeySSH != "":
+			raw, err := os.ReadFile(addKeySSH)
+			ensure(err)
+			k, err = plainsecrets.NewSSHKey(addKey, string(raw))
+			ensure(err)
+			// the keyring resolves relative paths against its own directory
+			k.SSHPrivateKeyPath, err = filepath.Abs(strings.TrimSuffix(addKeySSH, ".pub"))
+			ensure(err)
+			if home, err := os.UserHomeDir(); err == nil {
+				if rest, ok := strings.CutPrefix(k.SSHPrivateKeyPath, home+string(filepath.Separator)); ok {
+					k.SSHPrivateKeyPath = "~/" + filepath.ToSlash(rest)
+				}
+			}
+		case addKeyType == "symmetric":
+			k = plainsecrets.NewKey(addKey)
+		case addKeyType == "box-private":
+			k = plainsecrets.NewBoxKey(addKey)
+		default:
+			log.Fatalf("*** invalid -keytype %q.", addKeyType)
+		}
+		if addKeyCipher != "" {
+			k.Cipher, err = plainsecrets.ParseCipher(addKeyCipher)
+			ensure(err)
+			if k.Cipher != plainsecrets.SecretBox && k.Type != plainsecrets.SymmetricKey {
+				log.Fatalf("*** -cipher can only be used with symmetric keys.")
 			}
-			log.Fatalf("*** either -f or -fe must be specified.")
 		}
+		k.Scope = strings.Fields(addKeyScope)
+		k.Description = addKeyDesc
+		keyring.Add(k)
+		saveKeyring(keyringFile, keyring)
+	}
+
+	if secretsFile == "" && secretsEnv != "" {
 		secretsFile = os.Getenv(secretsEnv)
 		if secretsFile == "" {
 			log.Fatalf("*** missing environment variable %s.", secretsEnv)
 		}
 	}
 
+	switch flag.Arg(0) {
+	case "passwd":
+		changePassphrase(keyringFile, keyring, flag.Args()[1:])
+		return
+	case "keys":
+		keysCommand(keyringFile, secretsFile, keyring, flag.Args()[1:])
+		return
+	}
+
+	if secretsFile == "" {
+		if addKey != "" {
+			return
+		}
+		log.Fatalf("*** either -f or -fv must be specified.")
+	}
+
+	// keys are loaded lazily, so that e.g. a helper is not invoked unless needed
+	keys := plainsecrets.KeyChain{keyring}
+	if keyDir != "" {
+		keys = append(keys, plainsecrets.DirKeyProvider{Dir: keyDir})
+	}
+	if keyFD >= 0 {
+		keys = append(keys, plainsecrets.NewReaderKeyProvider(os.NewFile(uintptr(keyFD), "keyring")))
+	}
+	if keyHelper != "" {
+		keys = append(keys, plainsecrets.NewHelperKeyProvider(strings.Fields(keyHelper)...))
+	}
+	keys = append(keys, plainsecrets.EnvKeyProvider{})
+
 	vals, err := plainsecrets.ParseFile(secretsFile)
 	if err != nil && os.IsNotExist(err) {
 		err = nil
@@ -73,6 +170,74 @@ func main() {
 	if err != nil {
 		ensure(err)
 	}
+	vals.Fingerprints = fingerprints
+
+	switch flag.Arg(0) {
+	case "schedule":
+		now := time.Now()
+		for _, v := range vals.Upcoming() {
+			fmt.Printf("%s  %s (in %s)\n", v.ActiveFrom.Format(time.RFC3339), v.RawLHS, formatDuration(v.ActiveFrom.Sub(now)))
+		}
+		return
+	case "lint":
+		problems := vals.Lint()
+		for _, v := range problems {
+			log.Printf("%s: %v", v.RawLHS, v.Err)
+		}
+		if len(problems) > 0 {
+			os.Exit(1)
+		}
+		return
+	case "rotate-value":
+		rotateValue(secretsFile, vals, keys, key, flag.Args()[1:])
+		return
+	case "repad":
+		fs := flag.NewFlagSet("repad", flag.ExitOnError)
+		fs.IntVar(&vals.Padding, "pad", 0, "pad to a multiple of this many bytes, overriding the PADDING setting")
+		fs.Parse(flag.Args()[1:])
+		n, failed, err := vals.RepadAllInFile(secretsFile, keys)
+		ensure(err)
+		for _, v := range failed {
+			log.Printf("** cannot repad %s: %v", v.RawLHS, v.Err)
+		}
+		log.Printf("%d repadded.", n)
+		return
+	case "sign", "verify":
+		if key == "" {
+			log.Fatalf("*** %s requires -k.", flag.Arg(0))
+		}
+		k, err := keys.FindKey(key)
+		ensure(err)
+		if k == nil {
+			log.Fatalf("*** key %s not found.", key)
+		}
+		if flag.Arg(0) == "sign" {
+			serial, err := plainsecrets.SignFile(secretsFile, k)
+			ensure(err)
+			log.Printf("signed with %s, serial %d.", k.Ref(), serial)
+			return
+		}
+		fs := flag.NewFlagSet("verify", flag.ExitOnError)
+		minSerial := fs.Int("min-serial", 0, "refuse files with a lower serial")
+		fs.Parse(flag.Args()[1:])
+		ensure(vals.Verify(k, *minSerial))
+		log.Printf("signed with %s, serial %d.", k.Ref(), vals.Serial())
+		return
+	case "recipients":
+		args := flag.Args()[1:]
+		if len(args) < 2 || (args[0] != "add" && args[0] != "remove") {
+			log.Fatalf("*** usage: plainsecrets recipients add|remove KEY [NAME[.env]...]")
+		}
+		var n int
+		if args[0] == "add" {
+			n, err = vals.AddRecipientInFile(secretsFile, args[2:], args[1], keys)
+		} else {
+			n, err = plainsecrets.RemoveRecipientInFile(secretsFile, args[2:], args[1])
+		}
+		ensure(err)
+		log.Printf("updated %d values.", n)
+		return
+	}
 
 	if flag.NArg() > 0 {
 		patterns := flag.Args()
@@ -97,15 +262,15 @@ func main() {
 			}
 
 			if env == "" {
-				for _, v := range vals.ValueVariants(name, keyring) {
+				for _, v := range vals.ValueVariants(name, keys) {
 					if v.Err != nil {
-						fmt.Printf("# %s.%s -> ** %v\n", name, v.Env, v.Err)
+						fmt.Printf("# %s.%s%s -> ** %v\n", name, v.Env, variantSuffix(v), v.Err)
 					} else {
-						fmt.Printf("%s.%s=%s\n", name, v.Env, v.Value)
+						fmt.Printf("%s.%s%s=%s\n", name, v.Env, variantSuffix(v), v.Value)
 					}
 				}
 			} else {
-				val, err := vals.Value(name, env, keyring)
+				val, err := vals.Value(name, env, keys)
 				if err != nil {
 					fmt.Printf("# %s -> ** %v\n", name, err)
 				} else {
@@ -114,7 +279,7 @@ func main() {
 			}
 		}
 	} else {
-		n, failed, err := vals.EncryptAllInFile(secretsFile, keyring)
+		n, failed, err := vals.EncryptAllInFile(secretsFile, keys)
 		ensure(err)
 		for _, v := range failed {
 			log.Printf("** cannot encrypt %s: %v", v.Raw(), v.Err)
@@ -129,6 +294,58 @@ func main() {
 	// secret:v1:bubblehouse-prod:sdfsdfsdfsd:dsfdsfdsfds
 }
 
+func rotateValue(secretsFile string, vals *plainsecrets.Values, keys plainsecrets.KeyProvider, keyName string, args []string) {
+	fs := flag.NewFlagSet("rotate-value", flag.ExitOnError)
+	keep := fs.Int("keep", 1, "number of previous values to keep")
+	genSpec := fs.String("gen", "", "generate the new value according to this spec, e.g. hex:32")
+	fs.Parse(args)
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		log.Fatalf("*** usage: plainsecrets rotate-value [-keep N] [-gen SPEC] NAME[.env] [VALUE]")
+	}
+
+	lhs := fs.Arg(0)
+	_, env, found := strings.Cut(lhs, ".")
+	if !found {
+		env = plainsecrets.All
+	}
+
+	var val string
+	var err error
+	if *genSpec != "" {
+		val, err = plainsecrets.Generate(*genSpec)
+		ensure(err)
+	} else if fs.NArg() == 2 {
+		val = fs.Arg(1)
+	} else {
+		raw, err := io.ReadAll(os.Stdin)
+		ensure(err)
+		val = strings.TrimSuffix(string(raw), "\n")
+	}
+
+	rhs, err := vals.EncryptValue(val, env, keyName, keys)
+	ensure(err)
+	ensure(plainsecrets.RotateValueInFile(secretsFile, lhs, rhs, *keep))
+	log.Printf("rotated %s.", lhs)
+}
+
+func variantSuffix(v *plainsecrets.Variant) string {
+	var suffix string
+	if v.Generation > 0 {
+		suffix += fmt.Sprintf("~%d", v.Generation)
+	}
+	if !v.ActiveFrom.IsZero() {
+		suffix += "@" + v.ActiveFrom.Format(time.RFC3339)
+	}
+	return suffix
+}
+
+func formatDuration(d time.Duration) string {
+	if d >= 48*time.Hour {
+		return fmt.Sprintf("%d days", d/(24*time.Hour))
+	}
+	return d.Round(time.Minute).String()
+}
+
 func ensure(err error) {
 	if err != nil {
 		log.Fatalf("*** %v", err)
@@ -0,0 +1,94 @@
+package plainsecrets
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+var errDecryptionFailed = errors.New("decryption failed")
+
+// seal encrypts plaintext with the key. Symmetric keys use the key's cipher
+// with a random nonce, box keys produce an anonymous sealed box and no nonce.
+func seal(key *Key, plaintext []byte) (nonce, ciphertext []byte, err error) {
+	switch key.Type {
+	case SymmetricKey:
+		secret, err := key.secret()
+		if err != nil {
+			return nil, nil, err
+		}
+		return key.Cipher.seal(secret, plaintext)
+	case BoxPrivateKey, BoxPublicKey, SSHKey:
+		if err := key.checkOpen(); err != nil {
+			return nil, nil, err
+		}
+		pub := &key.Data
+		if key.Type == BoxPrivateKey {
+			pub = &key.PublicKey().Data
+		}
+		ciphertext, err := box.SealAnonymous(nil, plaintext, pub, rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, ciphertext, nil
+	default:
+		panic("unreachable")
+	}
+}
+
+// open decrypts a ciphertext produced by seal. The cipher is the one recorded
+// in the value, which need not match the key's current cipher.
+func open(key *Key, c Cipher, nonce, ciphertext []byte) ([]byte, error) {
+	var plaintext []byte
+	var ok bool
+	switch key.Type {
+	case SymmetricKey:
+		if len(nonce) == 0 {
+			return nil, fmt.Errorf("key %s is symmetric, but the value has no nonce", key.Name)
+		} else if len(nonce) != c.NonceSize() {
+			return nil, fmt.Errorf("invalid nonce size %d for %s", len(nonce), c)
+		}
+		secret, err := key.secret()
+		if err != nil {
+			return nil, err
+		}
+		plaintext, ok = c.open(secret, nonce, ciphertext)
+	case BoxPrivateKey, SSHKey:
+		if c != SecretBox {
+			return nil, fmt.Errorf("key %s is a box key, but the value uses %s", key.Name, c)
+		}
+		if len(nonce) != 0 {
+			return nil, fmt.Errorf("key %s is a box key, but the value has a nonce", key.Name)
+		}
+		pub, priv, err := key.boxKeys()
+		if err != nil {
+			return nil, err
+		}
+		plaintext, ok = box.OpenAnonymous(nil, ciphertext, pub, priv)
+	case BoxPublicKey:
+		return nil, fmt.Errorf("key %s is a public key, cannot decrypt", key.Name)
+	default:
+		panic("unreachable")
+	}
+	if !ok {
+		return nil, errDecryptionFailed
+	}
+	return plaintext, nil
+}
+
+// openRef is like open, but explains a decryption failure in terms of the key
+// fingerprint recorded in the value (if any), which catches the common case of
+// two different keys having the same name.
+func openRef(key *Key, fingerprint string, c Cipher, nonce, ciphertext []byte) ([]byte, error) {
+	plaintext, err := open(key, c, nonce, ciphertext)
+	if err == errDecryptionFailed {
+		if fingerprint == "" {
+			return nil, fmt.Errorf("decryption failed, your keyring has %s", key.Ref())
+		} else if key.Fingerprint() != fingerprint {
+			return nil, fmt.Errorf("this was encrypted with %s#%s, your keyring has %s", key.Name, fingerprint, key.Ref())
+		}
+	}
+	return plaintext, err
+}
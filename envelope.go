@@ -0,0 +1,379 @@
+package plainsecrets
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// An envelope encrypts the value once with a random data key, and wraps the
+// data key separately for each recipient key:
+//
+//	envelope:<nonce>:<ciphertext>:<key1>=<wrapped1>,<key2>=<wrapped2>[:padded]
+//
+// Any one of the recipient keys can decrypt the value, and recipients can be
+// added or removed without re-encrypting the value itself. The value is
+// encrypted with secretbox; data keys wrapped using other ciphers are written
+// as <key>/<cipher>=<wrapped>.
+type recipient struct {
+	KeyName        string
+	KeyFingerprint string
+	Cipher         Cipher
+	Wrapped        []byte // nonce followed by ciphertext for symmetric keys, sealed box otherwise
+}
+
+func (r *recipient) keyRef() string {
+	ref := r.KeyName
+	if r.KeyFingerprint != "" {
+		ref += "#" + r.KeyFingerprint
+	}
+	if r.Cipher != SecretBox {
+		ref += "/" + r.Cipher.String()
+	}
+	return ref
+}
+
+// EncryptEnvelope encrypts the value so that any of the given keys can decrypt it.
+func (vals *Values) EncryptEnvelope(val string, env string, keyNames []string, keyring KeyProvider) (string, error) {
+	var dataKey [KeySize]byte
+	if _, err := io.ReadFull(rand.Reader, dataKey[:]); err != nil {
+		return "", fmt.Errorf("failed to generate data key: %w", err)
+	}
+	nonce, ciphertext, padded, err := vals.sealPadded(&Key{Data: dataKey}, []byte(val), env)
+	if err != nil {
+		return "", err
+	}
+
+	e := &entry{Encoding: Envelope, Nonce: nonce, Ciphertext: ciphertext, Padded: padded}
+	for _, keyName := range keyNames {
+		if err := vals.addRecipient(e, env, keyName, &dataKey, keyring); err != nil {
+			return "", err
+		}
+	}
+	return e.envelopeRHS(), nil
+}
+
+func (vals *Values) addRecipient(e *entry, env, keyName string, dataKey *[KeySize]byte, keyring KeyProvider) error {
+	if e.recipient(keyName) != nil {
+		return fmt.Errorf("key %s is already a recipient", keyName)
+	}
+	key, err := vals.encryptionKey(env, keyName, keyring)
+	if err != nil {
+		return err
+	}
+	nonce, wrapped, err := seal(key, dataKey[:])
+	if err != nil {
+		return err
+	}
+	r := recipient{KeyName: key.Name, Cipher: key.Cipher, Wrapped: append(nonce, wrapped...)}
+	if vals.Fingerprints {
+		r.KeyFingerprint = key.Fingerprint()
+	}
+	e.Recipients = append(e.Recipients, r)
+	return nil
+}
+
+// AddRecipient wraps the data key of an envelope value for one more key,
+// using whichever existing recipient key is available to unwrap it. The
+// unwrapping is reported to OnDecrypt with an empty name.
+func (vals *Values) AddRecipient(rhs, env, keyName string, keyring KeyProvider) (string, error) {
+	return vals.addRecipientToRHS("", rhs, env, keyName, keyring)
+}
+
+func (vals *Values) addRecipientToRHS(name, rhs, env, keyName string, keyring KeyProvider) (string, error) {
+	e, err := parseEnvelopeRHS(rhs)
+	if err != nil {
+		return "", err
+	}
+	dataKey, usedKey, err := e.unwrapDataKey(keyring)
+	vals.audit(name, env, e, usedKey, err)
+	if err != nil {
+		return "", err
+	}
+	if err := vals.addRecipient(e, env, keyName, dataKey, keyring); err != nil {
+		return "", err
+	}
+	return e.envelopeRHS(), nil
+}
+
+// RemoveRecipient drops the wrapping of the data key for the given key. The
+// last recipient cannot be removed.
+func RemoveRecipient(rhs, keyName string) (string, error) {
+	e, err := parseEnvelopeRHS(rhs)
+	if err != nil {
+		return "", err
+	}
+	var kept []recipient
+	for _, r := range e.Recipients {
+		if r.KeyName != keyName {
+			kept = append(kept, r)
+		}
+	}
+	if len(kept) == len(e.Recipients) {
+		return "", fmt.Errorf("key %s is not a recipient", keyName)
+	} else if len(kept) == 0 {
+		return "", fmt.Errorf("cannot remove the only recipient %s", keyName)
+	}
+	e.Recipients = kept
+	return e.envelopeRHS(), nil
+}
+
+func parseEnvelopeRHS(rhs string) (*entry, error) {
+	e := &entry{}
+	if err := parseValue(rhs, e); err != nil {
+		return nil, err
+	}
+	if e.Encoding != Envelope {
+		return nil, fmt.Errorf("not an envelope value")
+	}
+	return e, nil
+}
+
+func parseEnvelope(str string, e *entry) error {
+	const format = `"envelope:<nonce>:<ciphertext>:<keyname>=<wrapped>,..."`
+	comps := strings.Split(str, ":")
+	if len(comps) != 3 {
+		return fmt.Errorf(`invalid envelope value, expected %s`, format)
+	}
+	nonce, err := base64.StdEncoding.DecodeString(comps[0])
+	if err != nil || len(nonce) != NonceSize {
+		return fmt.Errorf(`invalid nonce in %s`, format)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(comps[1])
+	if err != nil {
+		return fmt.Errorf(`invalid ciphertext in %s: %w`, format, err)
+	}
+
+	e.Encoding = Envelope
+	e.Nonce = nonce
+	e.Ciphertext = ciphertext
+	var names []string
+	for _, item := range strings.Split(comps[2], ",") {
+		keyRef, wrappedStr, _ := strings.Cut(item, "=")
+		var r recipient
+		keyRef, cipherName, hasCipher := strings.Cut(keyRef, "/")
+		if hasCipher {
+			if r.Cipher, err = ParseCipher(cipherName); err != nil {
+				return err
+			}
+		}
+		var ok bool
+		r.KeyName, r.KeyFingerprint, ok = parseKeyRef(keyRef)
+		if !ok {
+			return fmt.Errorf(`invalid key name %q in %s`, keyRef, format)
+		}
+		r.Wrapped, err = base64.StdEncoding.DecodeString(wrappedStr)
+		if err != nil || len(r.Wrapped) == 0 {
+			return fmt.Errorf(`invalid wrapped key for %s in %s`, r.KeyName, format)
+		}
+		if contains(names, r.KeyName) {
+			return fmt.Errorf(`duplicate recipient %s in %s`, r.KeyName, format)
+		}
+		names = append(names, r.KeyName)
+		e.Recipients = append(e.Recipients, r)
+	}
+	e.KeyName = strings.Join(names, ",")
+	return nil
+}
+
+func (e *entry) envelopeRHS() string {
+	var buf strings.Builder
+	buf.WriteString("envelope:")
+	buf.WriteString(base64.StdEncoding.EncodeToString(e.Nonce))
+	buf.WriteByte(':')
+	buf.WriteString(base64.StdEncoding.EncodeToString(e.Ciphertext))
+	buf.WriteByte(':')
+	for i, r := range e.Recipients {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(r.keyRef())
+		buf.WriteByte('=')
+		buf.WriteString(base64.StdEncoding.EncodeToString(r.Wrapped))
+	}
+	if e.Padded {
+		buf.WriteString(paddedSuffix)
+	}
+	return buf.String()
+}
+
+func (e *entry) recipient(keyName string) *recipient {
+	for i := range e.Recipients {
+		if e.Recipients[i].KeyName == keyName {
+			return &e.Recipients[i]
+		}
+	}
+	return nil
+}
+
+// unwrapDataKey unwraps the data key using the first recipient key in the
+// keyring that works, and returns the name of that key.
+func (e *entry) unwrapDataKey(keyring KeyProvider) (*[KeySize]byte, string, error) {
+	var names []string
+	var firstErr error
+	for _, r := range e.Recipients {
+		names = append(names, r.KeyName)
+		if keyring == nil {
+			continue
+		}
+		key, err := keyring.FindKey(r.KeyName)
+		if err != nil {
+			// another recipient key may still be available
+			if firstErr == nil {
+				firstErr = fmt.Errorf("key %s: %w", r.KeyName, err)
+			}
+			continue
+		}
+		if key == nil {
+			continue
+		}
+		dataKey, err := r.unwrap(key)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		return dataKey, r.KeyName, nil
+	}
+	if firstErr != nil {
+		return nil, "", firstErr
+	}
+	return nil, "", fmt.Errorf("missing all of recipient keys %s", strings.Join(names, ", "))
+}
+
+func (r *recipient) unwrap(key *Key) (*[KeySize]byte, error) {
+	var nonce []byte
+	wrapped := r.Wrapped
+	if key.Type == SymmetricKey {
+		n := r.Cipher.NonceSize()
+		if len(wrapped) < n {
+			return nil, fmt.Errorf("invalid wrapped key for %s", r.KeyName)
+		}
+		nonce, wrapped = wrapped[:n], wrapped[n:]
+	}
+	raw, err := openRef(key, r.KeyFingerprint, r.Cipher, nonce, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) != KeySize {
+		return nil, fmt.Errorf("invalid wrapped key for %s", r.KeyName)
+	}
+	return (*[KeySize]byte)(raw), nil
+}
+
+func (e *entry) openEnvelope(keyring KeyProvider) ([]byte, string, error) {
+	dataKey, keyName, err := e.unwrapDataKey(keyring)
+	if err != nil {
+		return nil, "", err
+	}
+	defer Wipe(dataKey[:])
+	plaintext, ok := secretbox.Open(nil, e.Ciphertext, (*[NonceSize]byte)(e.Nonce), dataKey)
+	if !ok {
+		return nil, "", errDecryptionFailed
+	}
+	return plaintext, keyName, nil
+}
+
+// AddRecipientInString adds the key as a recipient of the envelope values
+// with the given LHS (like NAME or NAME.env), or of all envelope values if
+// lhss is empty. Returns the number of values changed.
+func (vals *Values) AddRecipientInString(data string, lhss []string, keyName string, keyring KeyProvider) (string, int, error) {
+	return updateEnvelopesInString(data, lhss, func(lhs string, e *entry) (string, error) {
+		if len(lhss) == 0 && e.recipient(keyName) != nil {
+			return "", nil
+		}
+		return vals.addRecipientToRHS(lhsName(lhs), e.RawRHS, lhsEnv(lhs), keyName, keyring)
+	})
+}
+
+// RemoveRecipientInString is the opposite of AddRecipientInString.
+func RemoveRecipientInString(data string, lhss []string, keyName string) (string, int, error) {
+	return updateEnvelopesInString(data, lhss, func(lhs string, e *entry) (string, error) {
+		if len(lhss) == 0 && e.recipient(keyName) == nil {
+			return "", nil
+		}
+		return RemoveRecipient(e.RawRHS, keyName)
+	})
+}
+
+func (vals *Values) AddRecipientInFile(path string, lhss []string, keyName string, keyring KeyProvider) (int, error) {
+	return updateFile(path, func(data string) (string, int, error) {
+		return vals.AddRecipientInString(data, lhss, keyName, keyring)
+	})
+}
+
+func RemoveRecipientInFile(path string, lhss []string, keyName string) (int, error) {
+	return updateFile(path, func(data string) (string, int, error) {
+		return RemoveRecipientInString(data, lhss, keyName)
+	})
+}
+
+// updateEnvelopesInString calls f for the envelope values with the given
+// LHS, or all envelope values if lhss is empty, and replaces them with the
+// returned RHS unless it is empty.
+func updateEnvelopesInString(data string, lhss []string, f func(lhs string, e *entry) (string, error)) (string, int, error) {
+	pairs, err := parseKVPairs(data)
+	if err != nil {
+		return "", 0, err
+	}
+
+	lines := strings.Split(data, "\n")
+	var edits []lineEdit
+	var found []string
+	for _, p := range pairs {
+		if len(lhss) > 0 && !contains(lhss, p.Key) {
+			continue
+		}
+		found = append(found, p.Key)
+		e := &entry{RawLHS: p.Key, RawRHS: p.Value}
+		if err := parseValue(p.Value, e); err != nil {
+			return "", 0, fmt.Errorf("%w in %q", err, p.Key+"="+p.Value)
+		}
+		if e.Encoding != Envelope {
+			if len(lhss) > 0 {
+				return "", 0, fmt.Errorf("%s is not an envelope value", p.Key)
+			}
+			continue
+		}
+		rhs, err := f(p.Key, e)
+		if err != nil {
+			return "", 0, fmt.Errorf("%s: %w", p.Key, err)
+		} else if rhs == "" {
+			continue
+		}
+		edits = append(edits, lineEdit{p.Line, p.EndLine, []string{lhsPrefix(lines[p.Line-1]) + rhs}})
+	}
+	for _, lhs := range lhss {
+		if !contains(found, lhs) {
+			return "", 0, fmt.Errorf("%s not found", lhs)
+		}
+	}
+
+	return strings.Join(applyLineEdits(lines, edits), "\n"), len(edits), nil
+}
+
+// updateFile rewrites the file using f, unless f reports no changes.
+func updateFile(path string, f func(data string) (string, int, error)) (int, error) {
+	s, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	newData, n, err := f(string(raw))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return n, os.WriteFile(path, []byte(newData), s.Mode())
+}
@@ -0,0 +1,90 @@
+package plainsecrets
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEnvelope(t *testing.T) {
+	keyring := must(ParseKeyringString(sampleKeyring))
+	ops := NewBoxKey("ops")
+	keyring.Add(ops)
+	prodOnly := Keyring{keyring.ByName("myapp-prod")}
+	opsOnly := Keyring{ops.PublicKey()}
+
+	input := "@all = prod dev\nS.prod = enc:myapp-prod,ops:hello\nS.dev = enc:myapp-dev:x\n"
+	vals := must(ParseString(input))
+	output, n, failed := vals.EncryptAllInString(input, keyring)
+	if n != 2 || len(failed) > 0 {
+		t.Fatalf("** encrypted %d, failed %v", n, failed)
+	}
+	if !strings.Contains(output, "S.prod = envelope:") {
+		t.Fatalf("** got:\n%s", output)
+	}
+
+	vals = must(ParseString(output))
+	if a, e := tostr3(vals.Value("S", "prod", prodOnly)), "hello"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+	if a, e := tostr3(vals.Value("S", "prod", Keyring{ops})), "hello"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+	if a, e := tostr3(vals.Value("S", "prod", opsOnly)), "ERR: S: key ops is a public key, cannot decrypt"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+	if a, e := tostr3(vals.Value("S", "prod", append(opsOnly, prodOnly...))), "hello"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+	if a, e := tostr3(vals.Value("S", "prod", Keyring{keyring.ByName("myapp-dev")})), "ERR: S: missing all of recipient keys myapp-prod, ops"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+
+	// a provider failing for one recipient doesn't stop the others
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "myapp-prod"), 0700) // reading it fails
+	failing := DirKeyProvider{Dir: dir}
+	if a, e := tostr3(vals.Value("S", "prod", KeyChain{failing, Keyring{ops}})), "hello"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+	if _, err := vals.Value("S", "prod", failing); err == nil || !strings.Contains(err.Error(), "key myapp-prod: ") {
+		t.Errorf("** got %v, wanted the provider error", err)
+	}
+	if a, e := strings.Join(vals.KeyUsage()["ops"], " "), "S.prod"; a != e {
+		t.Errorf("** ops usage = %q, wanted %q", a, e)
+	}
+
+	// removing needs no keys, adding needs any one of the existing recipients
+	removed, n, err := RemoveRecipientInString(output, nil, "myapp-prod")
+	if err != nil || n != 1 {
+		t.Fatalf("** removed %d: %v", n, err)
+	}
+	if a, e := tostr3(must(ParseString(removed)).Value("S", "prod", prodOnly)), "ERR: S: missing all of recipient keys ops"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+	if _, _, err := RemoveRecipientInString(removed, []string{"S.prod"}, "ops"); err == nil || !strings.Contains(err.Error(), "cannot remove the only recipient ops") {
+		t.Errorf("** got %v, wanted an error", err)
+	}
+	if _, _, err := vals.AddRecipientInString(removed, []string{"S.prod"}, "myapp-prod", prodOnly); err == nil {
+		t.Errorf("** added a recipient without any existing recipient key")
+	}
+	added, n, err := vals.AddRecipientInString(removed, nil, "myapp-prod", Keyring{ops, keyring.ByName("myapp-prod")})
+	if err != nil || n != 1 {
+		t.Fatalf("** added %d: %v", n, err)
+	}
+	if a, e := tostr3(must(ParseString(added)).Value("S", "prod", prodOnly)), "hello"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+	if _, _, err := vals.AddRecipientInString(added, []string{"S.dev"}, "ops", keyring); err == nil || err.Error() != "S.dev is not an envelope value" {
+		t.Errorf("** got %v, wanted an error", err)
+	}
+
+	renamed, n, err := RenameKeyInString(added, "ops", "ops-breakglass")
+	if err != nil || n != 1 {
+		t.Fatalf("** renamed %d: %v", n, err)
+	}
+	if a, e := strings.Join(must(ParseString(renamed)).KeyUsage()["ops-breakglass"], " "), "S.prod"; a != e {
+		t.Errorf("** renamed usage = %q, wanted %q", a, e)
+	}
+}
@@ -10,15 +10,21 @@ import (
 func main() {
 	log.SetFlags(0)
 	var env string
+	var reveal bool
 	flag.StringVar(&env, "env", "dev", "environment")
+	flag.BoolVar(&reveal, "reveal", false, "print actual values instead of [REDACTED]")
 	flag.Parse()
 
 	keyring := must(plainsecrets.ParseKeyringFile("testdata/keyring.txt"))
 
-	values := must(plainsecrets.LoadFileValues("testdata/secrets.txt", env, keyring, true))
+	secrets := must(plainsecrets.LoadFileSecrets("testdata/secrets.txt", env, keyring, true))
 	log.Printf("Secrets for env %s:", env)
-	for k, v := range values {
-		log.Printf("\t%s = %s", k, v)
+	for k, v := range secrets {
+		if reveal {
+			log.Printf("\t%s = %s", k, v.Reveal())
+		} else {
+			log.Printf("\t%s = %v", k, v)
+		}
 	}
 }
 
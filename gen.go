@@ -0,0 +1,161 @@
+package plainsecrets
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const maxGenSize = 4096
+
+var passwordCharsets = map[string]string{
+	"alnum":   "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789",
+	"alpha":   "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz",
+	"digits":  "0123456789",
+	"symbols": "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789!#$%&*+-.:=?@^_~",
+}
+
+// Generate produces a random value according to a gen: spec like hex:32,
+// base64:32, password:24:alnum or ed25519.
+func Generate(spec string) (string, error) {
+	if err := validateGenSpec(spec); err != nil {
+		return "", err
+	}
+	kind, args, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "hex":
+		size, _ := strconv.Atoi(args)
+		return hex.EncodeToString(randomBytes(size)), nil
+	case "base64":
+		size, _ := strconv.Atoi(args)
+		return base64.StdEncoding.EncodeToString(randomBytes(size)), nil
+	case "password":
+		sizeStr, charsetName, _ := strings.Cut(args, ":")
+		if charsetName == "" {
+			charsetName = "alnum"
+		}
+		size, _ := strconv.Atoi(sizeStr)
+		return randomString(size, passwordCharsets[charsetName]), nil
+	case "ed25519":
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return "", err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(priv)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))), nil
+	default:
+		panic("unreachable")
+	}
+}
+
+func validateGenSpec(spec string) error {
+	kind, args, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "hex", "base64":
+		return validateGenSize(spec, args)
+	case "password":
+		sizeStr, charsetName, _ := strings.Cut(args, ":")
+		if charsetName != "" && passwordCharsets[charsetName] == "" {
+			return fmt.Errorf("unknown charset %q in gen:%s, expected one of %s", charsetName, spec, strings.Join(sortedKeys(passwordCharsets), ", "))
+		}
+		return validateGenSize(spec, sizeStr)
+	case "ed25519":
+		if args != "" {
+			return fmt.Errorf("unexpected arguments in gen:%s", spec)
+		}
+		return nil
+	default:
+		return fmt.Errorf(`unknown generator in gen:%s, expected "hex:<bytes>", "base64:<bytes>", "password:<len>[:<charset>]" or "ed25519"`, spec)
+	}
+}
+
+func validateGenSize(spec, sizeStr string) error {
+	size, err := strconv.Atoi(sizeStr)
+	if err != nil || size <= 0 || size > maxGenSize {
+		return fmt.Errorf("invalid size %q in gen:%s", sizeStr, spec)
+	}
+	return nil
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		panic(fmt.Errorf("failed to generate random bytes: %w", err))
+	}
+	return b
+}
+
+func randomString(n int, charset string) string {
+	max := big.NewInt(int64(len(charset)))
+	b := make([]byte, n)
+	for i := range b {
+		k, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			panic(fmt.Errorf("failed to generate random number: %w", err))
+		}
+		b[i] = charset[k.Int64()]
+	}
+	return string(b)
+}
+
+// generationTargets returns the envs that get their own distinct generated
+// values for an entry covering a group, or nil if the entry covers a single
+// env and can be replaced in place. Wildcard members must be covered by
+// a group consisting of just that wildcard, e.g. @local = local-*.
+// Entries scheduled in the future are matched as of their activation time.
+func (vals *Values) generationTargets(name string, e *entry) ([]string, error) {
+	if e.Resolved.trivial != "" {
+		return nil, nil
+	}
+	at := vals.now()
+	if e.ActiveFrom.After(at) {
+		at = e.ActiveFrom
+	}
+
+	var result []string
+	for _, pat := range e.Resolved.included {
+		env := pat
+		if IsWildcard(pat) {
+			env = ""
+			for _, group := range sortedKeys(vals.envs) {
+				if res := vals.resolvedEnvs[group]; res != nil && len(res.included) == 1 && res.included[0] == pat {
+					env = group
+					break
+				}
+			}
+			if env == "" {
+				return nil, fmt.Errorf("cannot generate distinct values for %s, define a group like @name = %s", pat, pat)
+			}
+		}
+
+		best, err := vals.pickVariantAt(name, env, vals.entries[name], e.Generation, at)
+		if err != nil {
+			return nil, err
+		}
+		if best == e {
+			result = append(result, env)
+		}
+	}
+	return result, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
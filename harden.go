@@ -0,0 +1,166 @@
+package plainsecrets
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// LoadHardenedKeyring is an opt-in stricter ParseKeyringFile for servers.
+// It refuses keyring files that other users can read or that belong to
+// another user (like ssh does for private keys), and moves the key material
+// out of the Go heap into memory that is locked against swapping, surrounded
+// by guard pages and excluded from core dumps (on Linux; elsewhere it only
+// gets wiped on Close). Call Keyring.Close when done to wipe the keys; using
+// them afterwards fails.
+//
+// Key.Data of the returned keys is zeroed; the keys are used as usual.
+//
+// This narrows, but does not close, the window in which key material sits
+// on the heap: parsing works on string copies of the file (and of the
+// decrypted contents of a passphrase-protected keyring) that are left to the
+// garbage collector, and the private keys of SSH keys are read into the
+// heap when first used (Close wipes those).
+func LoadHardenedKeyring(path string) (Keyring, error) {
+	raw, err := readKeyringFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer Wipe(raw)
+
+	keyring, err := parseKeyringString(string(raw), path, filepath.Dir(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := keyring.harden(); err != nil {
+		keyring.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return keyring, nil
+}
+
+// readKeyringFile checks the permissions of the file it has opened, rather
+// than of whatever is at the path at the time of the check.
+func readKeyringFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkKeyringFileInfo(path, fi); err != nil {
+		return nil, err
+	}
+	raw := make([]byte, fi.Size())
+	if _, err := io.ReadFull(f, raw); err != nil {
+		Wipe(raw)
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return raw, nil
+}
+
+// harden moves the secret key material into locked memory. The Data of
+// public and SSH keys is a public key, and stays where it is.
+func (keyring Keyring) harden() error {
+	mem, err := allocLocked(len(keyring) * KeySize)
+	if err != nil {
+		return err
+	}
+	for _, key := range keyring {
+		if key.locked != nil || key.Type == BoxPublicKey || key.Type == SSHKey {
+			continue // public keys have nothing to hide
+		}
+		key.locked = mem.alloc()
+		key.lockedMem = mem
+		*key.locked = key.Data
+		key.Data = [KeySize]byte{}
+	}
+	if mem.used == 0 {
+		mem.release()
+	}
+	return nil
+}
+
+// Close wipes the key material of all keys, which fail with an error if used
+// afterwards, and releases the locked memory of a keyring loaded with
+// LoadHardenedKeyring.
+func (keyring Keyring) Close() {
+	for _, key := range keyring {
+		key.wipe()
+	}
+}
+
+func (key *Key) wipe() {
+	if !key.closed {
+		key.closedFP = key.Fingerprint()
+		key.closed = true
+	}
+	Wipe(key.Data[:])
+	if key.locked != nil {
+		Wipe(key.locked[:])
+		key.locked = nil
+		key.lockedMem.free()
+		key.lockedMem = nil
+	}
+	if key.ssh != nil && key.ssh.priv != nil {
+		Wipe(key.ssh.priv[:])
+	}
+}
+
+// secret returns the key material, which lives in locked memory for keys
+// loaded with LoadHardenedKeyring, or an error if the key has been closed.
+func (key *Key) secret() (*[KeySize]byte, error) {
+	if err := key.checkOpen(); err != nil {
+		return nil, err
+	}
+	return key.material(), nil
+}
+
+func (key *Key) checkOpen() error {
+	if key.closed {
+		return fmt.Errorf("key %s has been closed", key.Name)
+	}
+	return nil
+}
+
+// material is secret for callers that have checked that the key is open.
+func (key *Key) material() *[KeySize]byte {
+	if key.locked != nil {
+		return key.locked
+	}
+	return &key.Data
+}
+
+// Wipe zeroes the slice, e.g. a value returned by Values.ValueBytes.
+func Wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// lockedMemory hands out key-sized chunks of a locked memory region, which
+// is freed once all of them have been wiped.
+type lockedMemory struct {
+	mem   []byte // entire mapping, including guard pages
+	data  []byte
+	used  int
+	freed int
+}
+
+func (m *lockedMemory) alloc() *[KeySize]byte {
+	chunk := (*[KeySize]byte)(m.data[m.used : m.used+KeySize])
+	m.used += KeySize
+	return chunk
+}
+
+func (m *lockedMemory) free() {
+	m.freed += KeySize
+	if m.freed == m.used {
+		Wipe(m.data)
+		m.release()
+	}
+}
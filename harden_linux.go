@@ -0,0 +1,49 @@
+//go:build linux
+
+package plainsecrets
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// madvDontDump is MADV_DONTDUMP, which the syscall package does not define.
+const madvDontDump = 0x10
+
+// allocLocked maps n bytes (rounded up to whole pages) surrounded by
+// inaccessible guard pages, locks them into RAM and excludes them from core
+// dumps.
+func allocLocked(n int) (*lockedMemory, error) {
+	page := os.Getpagesize()
+	size := (n + page - 1) / page * page
+	if size == 0 {
+		size = page
+	}
+	mem, err := syscall.Mmap(-1, 0, size+2*page, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE|syscall.MAP_ANON)
+	if err != nil {
+		return nil, fmt.Errorf("cannot allocate memory for keys: %w", err)
+	}
+	m := &lockedMemory{mem: mem, data: mem[page : page+size]}
+	if err := syscall.Mprotect(mem[:page], syscall.PROT_NONE); err != nil {
+		m.release()
+		return nil, fmt.Errorf("cannot set up guard page: %w", err)
+	}
+	if err := syscall.Mprotect(mem[page+size:], syscall.PROT_NONE); err != nil {
+		m.release()
+		return nil, fmt.Errorf("cannot set up guard page: %w", err)
+	}
+	if err := syscall.Mlock(m.data); err != nil {
+		m.release()
+		return nil, fmt.Errorf("cannot lock memory for keys: %w (check ulimit -l)", err)
+	}
+	if err := syscall.Madvise(m.data, madvDontDump); err != nil {
+		m.release()
+		return nil, fmt.Errorf("cannot exclude keys from core dumps: %w", err)
+	}
+	return m, nil
+}
+
+func (m *lockedMemory) release() {
+	syscall.Munmap(m.mem)
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package plainsecrets
+
+// allocLocked falls back to regular memory, which only gets wiped on Close.
+func allocLocked(n int) (*lockedMemory, error) {
+	data := make([]byte, n)
+	return &lockedMemory{mem: data, data: data}, nil
+}
+
+func (m *lockedMemory) release() {}
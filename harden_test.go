@@ -0,0 +1,59 @@
+package plainsecrets
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadHardenedKeyring(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keyring")
+	if err := os.WriteFile(path, []byte(sampleKeyring), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadHardenedKeyring(path); err == nil || !strings.Contains(err.Error(), "too open") {
+		t.Fatalf("** got %v, wanted too open", err)
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	plain := must(ParseKeyringString(sampleKeyring))
+	keyring := must(LoadHardenedKeyring(path))
+	key := keyring.ByName("myapp-prod")
+	if key.Data != ([KeySize]byte{}) {
+		t.Errorf("** Data not zeroed")
+	}
+	if a, e := key.Ref(), plain.ByName("myapp-prod").Ref(); a != e {
+		t.Errorf("** got %s, wanted %s", a, e)
+	}
+	if a, e := keyring.Data(), plain.Data(); a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+
+	vals := must(ParseString("@all = prod\nA = " + must(New().EncryptValue("hello", "prod", "myapp-prod", plain))))
+	if a, e := tostr3(vals.Value("A", "prod", keyring)), "hello"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+	b := must(vals.ValueBytes("A", "prod", keyring))
+	if a, e := string(b), "hello"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+	Wipe(b)
+	if a, e := string(b), "\x00\x00\x00\x00\x00"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+
+	ref := key.Ref()
+	keyring.Close()
+	if a, e := tostr3(vals.Value("A", "prod", keyring)), "ERR: A: key myapp-prod has been closed"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+	if a, e := tostr3(vals.EncryptValue("hello", "prod", "myapp-prod", keyring)), "ERR: key myapp-prod has been closed"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+	if a, e := key.Ref(), ref; a != e {
+		t.Errorf("** got %s, wanted %s", a, e)
+	}
+}
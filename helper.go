@@ -0,0 +1,151 @@
+package plainsecrets
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultKeyHelperTimeout = 10 * time.Second
+
+// HelperKeyProvider fetches keys by running an external helper executable,
+// in the style of git credential helpers. The helper is invoked as
+//
+//	<command> get <keyname>
+//
+// with name=<keyname> and an empty line on stdin, and prints key=<base64>
+// on stdout, or nothing if it does not have the key. A non-zero exit status
+// is an error. Results are cached for the lifetime of the provider.
+type HelperKeyProvider struct {
+	Command []string
+	Timeout time.Duration // DefaultKeyHelperTimeout if zero
+
+	mu    sync.Mutex
+	cache map[string]*Key
+}
+
+func NewHelperKeyProvider(command ...string) *HelperKeyProvider {
+	return &HelperKeyProvider{Command: command}
+}
+
+func (p *HelperKeyProvider) FindKey(name string) (*Key, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, found := p.cache[name]; found {
+		return key, nil
+	}
+
+	key, err := p.run(name)
+	if err != nil {
+		return nil, err
+	}
+	if p.cache == nil {
+		p.cache = make(map[string]*Key)
+	}
+	p.cache[name] = key
+	return key, nil
+}
+
+func (p *HelperKeyProvider) run(name string) (*Key, error) {
+	if len(p.Command) == 0 {
+		return nil, fmt.Errorf("key helper not configured")
+	}
+	timeout := p.Timeout
+	if timeout == 0 {
+		timeout = DefaultKeyHelperTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	args := append(append([]string(nil), p.Command[1:]...), "get", name)
+	cmd := exec.CommandContext(ctx, p.Command[0], args...)
+	cmd.Stdin = strings.NewReader("name=" + name + "\n\n")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.WaitDelay = time.Second
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("key helper %s timed out after %v", p.Command[0], timeout)
+	} else if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("key helper %s failed: %w: %s", p.Command[0], err, msg)
+		}
+		return nil, fmt.Errorf("key helper %s failed: %w", p.Command[0], err)
+	}
+
+	attrs, err := readHelperAttrs(&stdout)
+	if err != nil {
+		return nil, fmt.Errorf("key helper %s: %w", p.Command[0], err)
+	}
+	data, found := attrs["key"]
+	if !found {
+		return nil, nil
+	}
+	key, err := parseKeyData(name, data)
+	if err != nil {
+		return nil, fmt.Errorf("key helper %s: %w", p.Command[0], err)
+	}
+	return key, nil
+}
+
+// ServeKeyHelper implements the helper side of the HelperKeyProvider
+// protocol on top of another provider. args are the command-line arguments
+// following the executable name.
+func ServeKeyHelper(args []string, stdin io.Reader, stdout io.Writer, keys KeyProvider) error {
+	if len(args) == 0 || args[0] != "get" {
+		return fmt.Errorf("usage: get <keyname>")
+	}
+	attrs, err := readHelperAttrs(stdin)
+	if err != nil {
+		return err
+	}
+	name := attrs["name"]
+	if len(args) > 1 {
+		name = args[1]
+	}
+	if name == "" {
+		return fmt.Errorf("missing key name")
+	}
+
+	key, err := keys.FindKey(name)
+	if err != nil {
+		return err
+	}
+	if key != nil {
+		secret, err := key.secret()
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(stdout, "key=%s\n", base64.StdEncoding.EncodeToString(secret[:]))
+		return err
+	}
+	return err
+}
+
+// readHelperAttrs reads key=value lines up to an empty line or EOF.
+func readHelperAttrs(r io.Reader) (map[string]string, error) {
+	attrs := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			break
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, errors.New("malformed line in helper protocol, expected key=value")
+		}
+		attrs[k] = v
+	}
+	return attrs, scanner.Err()
+}
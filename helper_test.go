@@ -0,0 +1,70 @@
+package plainsecrets
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHelperKeyProvider(t *testing.T) {
+	keyring := must(ParseKeyringString(sampleKeyring))
+	dir := t.TempDir()
+	keyringFile := filepath.Join(dir, "keyring.txt")
+	os.WriteFile(keyringFile, []byte(sampleKeyring), 0600)
+	bin := buildFakeKeyHelper(t, dir)
+	helper := func(flags ...string) []string {
+		return append([]string{bin, "-K", keyringFile}, flags...)
+	}
+
+	p := NewHelperKeyProvider(helper()...)
+	if k := must(p.FindKey("myapp-prod")); k == nil || k.Data != keyring.ByName("myapp-prod").Data {
+		t.Errorf("** myapp-prod = %v", k)
+	}
+	if k := must(p.FindKey("other")); k != nil {
+		t.Errorf("** other = %v", k)
+	}
+	p.Command = helper("-fail", "vault is locked")
+	if k := must(p.FindKey("myapp-prod")); k == nil {
+		t.Errorf("** myapp-prod not cached")
+	}
+
+	_, err := p.FindKey("myapp-dev")
+	if a, e := tostr3("", err), "ERR: key helper "+bin+" failed: exit status 1: vault is locked"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+
+	p = NewHelperKeyProvider(helper("-delay", "1m")...)
+	p.Timeout = 100 * time.Millisecond
+	_, err = p.FindKey("myapp-dev")
+	if a, e := tostr3("", err), "ERR: key helper "+bin+" timed out after 100ms"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+}
+
+// buildFakeKeyHelper builds cmd/plainsecrets-fake-key-helper into dir.
+func buildFakeKeyHelper(t *testing.T, dir string) string {
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool not available to build the fake key helper")
+	}
+	bin := filepath.Join(dir, "plainsecrets-fake-key-helper")
+	out, err := exec.Command(goBin, "build", "-o", bin, "./cmd/plainsecrets-fake-key-helper").CombinedOutput()
+	if err != nil {
+		t.Fatalf("** building fake key helper: %v\n%s", err, out)
+	}
+	return bin
+}
+
+func TestServeKeyHelper(t *testing.T) {
+	var out strings.Builder
+	err := ServeKeyHelper([]string{"get"}, strings.NewReader("name=myapp-dev\n\n"), &out, must(ParseKeyringString(sampleKeyring)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, e := out.String(), "key=5OnO+jqOo/hhz1DVJox3TpaefmbwFqbiw6HYfuogz+Y=\n"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+}
@@ -0,0 +1,237 @@
+package plainsecrets
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultHTTPTimeout  = 30 * time.Second
+	DefaultHTTPAttempts = 3
+	DefaultHTTPBackoff  = time.Second
+
+	maxSourceSize = 16 << 20
+)
+
+// HTTPSource fetches a secrets file from a URL. It makes conditional
+// requests (If-None-Match, If-Modified-Since) once it has a copy, retries
+// network errors and 5xx responses with exponential backoff, and keeps the
+// last good copy in CacheFile, which is served when the server cannot be
+// reached (including after a restart).
+type HTTPSource struct {
+	URL string
+
+	// Header is added to requests, e.g. for Authorization.
+	Header http.Header
+
+	// Client defaults to an http.Client with DefaultHTTPTimeout.
+	Client *http.Client
+
+	// Attempts is the total number of attempts, DefaultHTTPAttempts if zero.
+	Attempts int
+
+	// Backoff is the delay before the first retry, doubling with each one,
+	// DefaultHTTPBackoff if zero.
+	Backoff time.Duration
+
+	// CacheFile, if set, keeps the last good copy of the secrets file, with
+	// its ETag and Last-Modified in CacheFile.meta.
+	CacheFile string
+
+	// OnFallback, if set, is called when the cached copy is served because
+	// the server could not be reached.
+	OnFallback func(err error)
+
+	// AllowFileValues accepts fetched files with file:, encfile: and
+	// secretfile: values, which are only usable with Values.SetDir.
+	// Otherwise such files are refused like ParseSource does without
+	// SetDir, and never replace the cached copy.
+	AllowFileValues bool
+
+	mu    sync.Mutex
+	cache *cachedCopy
+
+	// signRequest, if set, signs each request after all headers are set
+	signRequest func(req *http.Request) error
+}
+
+type cachedCopy struct {
+	data         []byte
+	etag         string
+	lastModified string
+}
+
+// errRetryable marks failures worth retrying and falling back to the cache
+// for, as opposed to e.g. 404 or a malformed secrets file.
+type errRetryable struct {
+	err error
+}
+
+func (e errRetryable) Error() string { return e.err.Error() }
+func (e errRetryable) Unwrap() error { return e.err }
+
+func (src *HTTPSource) Fetch(ctx context.Context) ([]byte, error) {
+	attempts := src.Attempts
+	if attempts <= 0 {
+		attempts = DefaultHTTPAttempts
+	}
+	backoff := src.Backoff
+	if backoff <= 0 {
+		backoff = DefaultHTTPBackoff
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		var data []byte
+		data, err = src.attempt(ctx)
+		if err == nil {
+			return data, nil
+		}
+		if !errors.As(err, new(errRetryable)) || attempt >= attempts || ctx.Err() != nil {
+			break
+		}
+		// other fetches may proceed while this one waits
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+		}
+		backoff *= 2
+	}
+
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	if errors.As(err, new(errRetryable)) && src.cache != nil {
+		if src.OnFallback != nil {
+			src.OnFallback(err)
+		}
+		return src.cache.data, nil
+	}
+	return nil, err
+}
+
+// attempt makes a single request, updating the cache on success.
+func (src *HTTPSource) attempt(ctx context.Context) ([]byte, error) {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	if src.cache == nil && src.CacheFile != "" {
+		src.cache = readCachedCopy(src.CacheFile)
+	}
+	fetched, err := src.fetchOnce(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if fetched != src.cache {
+		src.cache = fetched
+		if src.CacheFile != "" {
+			if err := fetched.write(src.CacheFile); err != nil {
+				return nil, fmt.Errorf("%s: %w", src.CacheFile, err)
+			}
+		}
+	}
+	return src.cache.data, nil
+}
+
+// fetchOnce returns src.cache itself if the server says it's not modified.
+func (src *HTTPSource) fetchOnce(ctx context.Context) (*cachedCopy, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range src.Header {
+		req.Header[k] = v
+	}
+	if src.cache != nil {
+		if src.cache.etag != "" {
+			req.Header.Set("If-None-Match", src.cache.etag)
+		}
+		if src.cache.lastModified != "" {
+			req.Header.Set("If-Modified-Since", src.cache.lastModified)
+		}
+	}
+
+	if src.signRequest != nil {
+		if err := src.signRequest(req); err != nil {
+			return nil, err
+		}
+	}
+
+	client := src.Client
+	if client == nil {
+		client = &http.Client{Timeout: DefaultHTTPTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errRetryable{err}
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && src.cache != nil:
+		return src.cache, nil
+	case resp.StatusCode == http.StatusOK:
+		break
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return nil, errRetryable{fmt.Errorf("%s: %s", src.URL, resp.Status)}
+	default:
+		return nil, fmt.Errorf("%s: %s", src.URL, resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSourceSize+1))
+	if err != nil {
+		return nil, errRetryable{fmt.Errorf("%s: %w", src.URL, err)}
+	}
+	if len(data) > maxSourceSize {
+		return nil, fmt.Errorf("%s: secrets file is too large", src.URL)
+	}
+	// only a complete secrets file that ParseSource accepts replaces the
+	// cached copy
+	vals := New()
+	vals.remote = true
+	if src.AllowFileValues {
+		vals.dir = "." // paths are still checked, but not resolved
+	}
+	if err := vals.ParseString(string(data)); err != nil {
+		return nil, fmt.Errorf("%s: %w", src.URL, err)
+	}
+	return &cachedCopy{data, resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")}, nil
+}
+
+func readCachedCopy(path string) *cachedCopy {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	c := &cachedCopy{data: data}
+	meta, err := os.ReadFile(path + ".meta")
+	if err != nil {
+		return c
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(meta))
+	for scanner.Scan() {
+		k, v, _ := strings.Cut(scanner.Text(), ": ")
+		switch k {
+		case "ETag":
+			c.etag = v
+		case "Last-Modified":
+			c.lastModified = v
+		}
+	}
+	return c
+}
+
+func (c *cachedCopy) write(path string) error {
+	if err := writeFileAtomically(path, c.data, 0o600); err != nil {
+		return err
+	}
+	meta := fmt.Sprintf("ETag: %s\nLast-Modified: %s\n", c.etag, c.lastModified)
+	return writeFileAtomically(path+".meta", []byte(meta), 0o600)
+}
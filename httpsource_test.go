@@ -0,0 +1,176 @@
+package plainsecrets
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHTTPSource(t *testing.T) {
+	var requests, notModified, failures atomic.Int32
+	data := "@all = prod\nURL = http://a\n"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if failures.Load() > 0 {
+			failures.Add(-1)
+			http.Error(w, "oops", http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(data))
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	cacheFile := filepath.Join(t.TempDir(), "secrets.txt")
+	newSource := func() *HTTPSource {
+		return &HTTPSource{URL: srv.URL, Header: http.Header{"Authorization": {"Bearer tok"}}, CacheFile: cacheFile, Backoff: time.Millisecond}
+	}
+	src := newSource()
+
+	if a, e := must(LoadSourceValues(ctx, src, "prod", nil))["URL"], "http://a"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+	if a, e := string(must(src.Fetch(ctx))), data; a != e || notModified.Load() != 1 {
+		t.Errorf("** got %q (%d not modified), wanted %q", a, notModified.Load(), e)
+	}
+
+	// retries 5xx
+	failures.Store(2)
+	requests.Store(0)
+	if a, e := string(must(src.Fetch(ctx))), data; a != e || requests.Load() != 3 {
+		t.Errorf("** got %q after %d requests, wanted %q", a, requests.Load(), e)
+	}
+
+	// a fresh source uses the cache file for conditional requests
+	notModified.Store(0)
+	if a, e := string(must(newSource().Fetch(ctx))), data; a != e || notModified.Load() != 1 {
+		t.Errorf("** got %q (%d not modified), wanted %q", a, notModified.Load(), e)
+	}
+
+	// falls back to the cache file when the server is down
+	failures.Store(100)
+	var fallbackErr error
+	src = newSource()
+	src.OnFallback = func(err error) { fallbackErr = err }
+	if a, e := string(must(src.Fetch(ctx))), data; a != e || fallbackErr == nil {
+		t.Errorf("** got %q (fallback error %v), wanted %q", a, fallbackErr, e)
+	}
+	failures.Store(0)
+
+	// but not on errors like 403
+	src = newSource()
+	src.Header = nil
+	if _, err := src.Fetch(ctx); err == nil {
+		t.Errorf("** got no error for 403")
+	}
+
+	// and not without a cache
+	srv.Close()
+	if _, err := (&HTTPSource{URL: srv.URL, Backoff: time.Millisecond}).Fetch(ctx); err == nil {
+		t.Errorf("** got no error with server down")
+	}
+}
+
+func TestHTTPSourceRefusals(t *testing.T) {
+	var failures atomic.Int32
+	var data atomic.Value
+	data.Store("@all = prod\nURL = http://a\n")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failures.Load() > 0 {
+			failures.Add(-1)
+			http.Error(w, "oops", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(data.Load().(string)))
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	cacheFile := filepath.Join(t.TempDir(), "secrets.txt")
+	src := &HTTPSource{URL: srv.URL, CacheFile: cacheFile, Backoff: time.Millisecond}
+	must(src.Fetch(ctx))
+
+	// a file that ParseSource would refuse does not replace the cached copy
+	data.Store("@all = prod\nCERT = file:cert.pem\n")
+	if _, err := src.Fetch(ctx); err == nil || !strings.Contains(err.Error(), "call SetDir") {
+		t.Errorf("** got %v, wanted file values refused", err)
+	}
+	if a, e := string(must(os.ReadFile(cacheFile))), "@all = prod\nURL = http://a\n"; a != e {
+		t.Errorf("** cached %q, wanted %q", a, e)
+	}
+	src.AllowFileValues = true
+	if a, e := string(must(src.Fetch(ctx))), "@all = prod\nCERT = file:cert.pem\n"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+	data.Store("@all = prod\nCERT = file:../cert.pem\n")
+	if _, err := src.Fetch(ctx); err == nil || !strings.Contains(err.Error(), "stay within") {
+		t.Errorf("** got %v, wanted escaping paths refused", err)
+	}
+
+	// other fetches go ahead while one is waiting to retry
+	failures.Store(1)
+	src.Backoff = time.Hour
+	waitCtx, cancel := context.WithCancel(ctx)
+	waiting := make(chan struct{})
+	go func() {
+		src.Fetch(waitCtx)
+		close(waiting)
+	}()
+	for failures.Load() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	done := make(chan struct{})
+	go func() {
+		src.Fetch(ctx)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Errorf("** fetch blocked by another one's backoff")
+	}
+	cancel()
+	<-waiting
+}
+
+type staticSource string
+
+func (s staticSource) Fetch(ctx context.Context) ([]byte, error) {
+	return []byte(s), nil
+}
+
+func TestSourceFileValues(t *testing.T) {
+	ctx := context.Background()
+	src := staticSource("@all = prod\nA = file:a.txt\n")
+	_, err := LoadSourceValues(ctx, src, "prod", nil)
+	if a, e := tostr3("", err), `ERR: path "a.txt" in a secrets file fetched from a source, call SetDir to allow file values in "A=file:a.txt"`; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello"), 0600)
+	vals := New()
+	vals.SetDir(dir)
+	if err := vals.ParseSource(ctx, src); err != nil {
+		t.Fatal(err)
+	}
+	if a, e := tostr3(vals.Value("A", "prod", nil)), "hello"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+}
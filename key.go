@@ -2,13 +2,73 @@ package plainsecrets
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"time"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/nacl/box"
 )
 
 type Key struct {
-	Name string
-	Data [KeySize]byte
+	Name   string
+	Type   KeyType
+	Cipher Cipher        // for symmetric keys
+	Data   [KeySize]byte // zeroed for keys loaded with LoadHardenedKeyring
+
+	Created     time.Time
+	Description string
+	Scope       []string // envs and env groups the key may encrypt for, any if empty
+	Comment     string   // comment lines preceding the key in a v2 keyring file
+
+	DeriveFrom string // name of the master key if the key is derived
+	DerivePath string // derivation path like myapp/prod
+
+	SSHPublicKey      string // authorized_keys line for SSH keys
+	SSHPrivateKeyPath string // private key file for SSH keys, ~/.ssh/id_ed25519 by default, relative to the keyring file
+	ssh               *sshPrivateKey
+
+	locked    *[KeySize]byte // key material in locked memory, see LoadHardenedKeyring
+	lockedMem *lockedMemory
+	closed    bool   // wiped by Keyring.Close
+	closedFP  string // fingerprint as of Close
+}
+
+type KeyType int
+
+const (
+	// SymmetricKey encrypts and decrypts using NaCl secretbox.
+	SymmetricKey = KeyType(iota)
+	// BoxPrivateKey is a Curve25519 private key, decrypts NaCl anonymous
+	// sealed boxes (and can encrypt them, too).
+	BoxPrivateKey
+	// BoxPublicKey is a Curve25519 public key, can only encrypt.
+	BoxPublicKey
+	// SSHKey is an SSH ed25519 public key converted to Curve25519, which
+	// encrypts NaCl anonymous sealed boxes; the matching SSH private key file
+	// is read to decrypt.
+	SSHKey
+)
+
+var keyTypeNames = []string{"symmetric", "box-private", "box-public", "ssh-ed25519"}
+
+func (kt KeyType) String() string {
+	if kt < 0 || int(kt) >= len(keyTypeNames) {
+		return fmt.Sprintf("KeyType(%d)", int(kt))
+	}
+	return keyTypeNames[kt]
+}
+
+func ParseKeyType(s string) (KeyType, error) {
+	for i, name := range keyTypeNames {
+		if s == name {
+			return KeyType(i), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid key type %q", s)
 }
 
 // String implements fmt.Stringer without exposing sensitive data.
@@ -17,10 +77,124 @@ func (key *Key) String() string {
 }
 
 func NewKey(name string) *Key {
-	key := &Key{Name: name}
+	key := &Key{Name: name, Created: time.Now().UTC().Truncate(time.Second)}
 	_, err := io.ReadFull(rand.Reader, key.Data[:])
 	if err != nil {
 		panic(fmt.Errorf("failed to generate random key: %w", err))
 	}
 	return key
 }
+
+// NewBoxKey generates a Curve25519 private key.
+func NewBoxKey(name string) *Key {
+	_, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(fmt.Errorf("failed to generate random key: %w", err))
+	}
+	return &Key{Name: name, Type: BoxPrivateKey, Data: *priv, Created: time.Now().UTC().Truncate(time.Second)}
+}
+
+// NewDerivedKey derives a subkey from the master key using HKDF-SHA256 with
+// the given path. Holding the subkey reveals nothing about the master key or
+// its other subkeys.
+func NewDerivedKey(name string, master *Key, path string) (*Key, error) {
+	if !IsValidDerivePath(path) {
+		return nil, fmt.Errorf("invalid derivation path %q, must be [%s]+", path, derivePathCharset)
+	}
+	if err := checkDeriveMaster(master); err != nil {
+		return nil, err
+	}
+	key := &Key{Name: name, DeriveFrom: master.Name, DerivePath: path, Created: time.Now().UTC().Truncate(time.Second)}
+	var err error
+	key.Data, err = deriveKeyData(master, path)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// checkDeriveMaster only allows deriving from secret key material; deriving
+// from a public key would produce keys anyone can compute.
+func checkDeriveMaster(master *Key) error {
+	if master.Type != SymmetricKey && master.Type != BoxPrivateKey {
+		return fmt.Errorf("cannot derive keys from %s, which is a %s key", master.Name, master.Type)
+	}
+	return nil
+}
+
+func deriveKeyData(master *Key, path string) (data [KeySize]byte, err error) {
+	secret, err := master.secret()
+	if err != nil {
+		return data, err
+	}
+	r := hkdf.New(sha256.New, secret[:], nil, []byte("plainsecrets derive "+path))
+	if _, err := io.ReadFull(r, data[:]); err != nil {
+		panic(err)
+	}
+	return data, nil
+}
+
+// PublicKey returns the public counterpart of a box private key, or the key
+// itself for other types. The public counterpart of a closed key is closed
+// too.
+func (key *Key) PublicKey() *Key {
+	if key.Type != BoxPrivateKey {
+		return key
+	}
+	pub := *key
+	pub.Type = BoxPublicKey
+	pub.locked, pub.lockedMem = nil, nil
+	if key.closed {
+		return &pub
+	}
+	b, err := curve25519.X25519(key.material()[:], curve25519.Basepoint)
+	if err != nil {
+		panic(err)
+	}
+	copy(pub.Data[:], b)
+	return &pub
+}
+
+// FingerprintLen is the number of hex chars in a key fingerprint.
+const FingerprintLen = 4
+
+// Fingerprint returns a short check value identifying the key material, so
+// that two different keys sharing a name can be told apart. The private and
+// public halves of a box key have the same fingerprint. Closed keys keep
+// the fingerprint they had.
+func (key *Key) Fingerprint() string {
+	if key.closed {
+		return key.closedFP
+	}
+	pub := key.PublicKey()
+	h := sha256.New()
+	h.Write([]byte("plainsecrets key fingerprint\x00"))
+	h.Write(pub.material()[:])
+	return hex.EncodeToString(h.Sum(nil))[:FingerprintLen]
+}
+
+// Ref returns name#fingerprint.
+func (key *Key) Ref() string {
+	return key.Name + "#" + key.Fingerprint()
+}
+
+func (key *Key) boxKeys() (pub, priv *[KeySize]byte, err error) {
+	switch key.Type {
+	case BoxPrivateKey:
+		priv, err := key.secret()
+		if err != nil {
+			return nil, nil, err
+		}
+		return &key.PublicKey().Data, priv, nil
+	case BoxPublicKey:
+		return &key.Data, nil, key.checkOpen()
+	case SSHKey:
+		if err := key.checkOpen(); err != nil {
+			return nil, nil, err
+		}
+		priv, err := key.sshPrivateKey()
+		return &key.Data, priv, err
+	default:
+		return nil, nil, fmt.Errorf("key %s is not a box key", key.Name)
+	}
+}
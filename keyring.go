@@ -4,8 +4,10 @@ import (
 	"encoding/base64"
 	"fmt"
 	"os"
+	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 )
 
 type Keyring []*Key
@@ -14,6 +16,8 @@ func (keyring *Keyring) Add(key *Key) {
 	*keyring = append(*keyring, key)
 }
 
+// ByName returns the key with the given name. Derived keys are resolved when
+// the keyring is parsed, so they are returned like any other key.
 func (keyring Keyring) ByName(name string) *Key {
 	for _, key := range keyring {
 		if key.Name == name {
@@ -23,6 +27,120 @@ func (keyring Keyring) ByName(name string) *Key {
 	return nil
 }
 
+// Remove deletes the key with the given name, returns false if not found.
+func (keyring *Keyring) Remove(name string) bool {
+	for i, key := range *keyring {
+		if key.Name == name {
+			*keyring = append((*keyring)[:i], (*keyring)[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (keyring Keyring) Rename(oldName, newName string) error {
+	key := keyring.ByName(oldName)
+	if key == nil {
+		return fmt.Errorf("key %s not found", oldName)
+	}
+	if !IsValidKeyName(newName) {
+		return fmt.Errorf("invalid key name %q, must be [%s]+", newName, keyNameCharset)
+	}
+	if keyring.ByName(newName) != nil {
+		return fmt.Errorf("key %s already exists", newName)
+	}
+	key.Name = newName
+	for _, k := range keyring {
+		if k.DeriveFrom == oldName {
+			k.DeriveFrom = newName
+		}
+	}
+	return nil
+}
+
+// resolveDerived computes the data of derived keys, which may be derived from
+// other derived keys.
+func (keyring Keyring) resolveDerived() error {
+	resolved := make(map[*Key]bool)
+	for {
+		var pending []*Key
+		var progress bool
+		for _, key := range keyring {
+			if key.DeriveFrom == "" || resolved[key] {
+				continue
+			}
+			master := keyring.ByName(key.DeriveFrom)
+			if master == nil {
+				return fmt.Errorf("%s: derived from missing key %s", key.Name, key.DeriveFrom)
+			}
+			if err := checkDeriveMaster(master); err != nil {
+				return fmt.Errorf("%s: %w", key.Name, err)
+			}
+			if master.DeriveFrom != "" && !resolved[master] {
+				pending = append(pending, key)
+				continue
+			}
+			data, err := deriveKeyData(master, key.DerivePath)
+			if err != nil {
+				return fmt.Errorf("%s: %w", key.Name, err)
+			}
+			key.Data = data
+			resolved[key], progress = true, true
+		}
+		if len(pending) == 0 {
+			return nil
+		} else if !progress {
+			return fmt.Errorf("%s: circular key derivation", pending[0].Name)
+		}
+	}
+}
+
+// encodedData returns derive:master:path for derived keys whose master is in
+// the keyring, and base64 data otherwise. Panics if the key has been closed.
+func (keyring Keyring) encodedData(key *Key) string {
+	secret, err := key.secret()
+	if err != nil {
+		panic(err)
+	}
+	if key.DeriveFrom != "" {
+		if master := keyring.ByName(key.DeriveFrom); master != nil {
+			if data, err := deriveKeyData(master, key.DerivePath); err == nil && data == *secret {
+				return "derive:" + key.DeriveFrom + ":" + key.DerivePath
+			}
+		}
+	}
+	return base64.StdEncoding.EncodeToString(secret[:])
+}
+
+// Import adds the given keys, skipping the ones that are already present.
+// A key with the same name but different data is an error, in which case
+// nothing is imported. Returns the number of keys added.
+func (keyring *Keyring) Import(keys Keyring) (int, error) {
+	var added Keyring
+	for _, key := range keys {
+		if existing := keyring.ByName(key.Name); existing != nil {
+			a, err := existing.secret()
+			if err != nil {
+				return 0, err
+			}
+			b, err := key.secret()
+			if err != nil {
+				return 0, err
+			}
+			if existing.Type != key.Type || *a != *b {
+				return 0, fmt.Errorf("key %s already exists with different data (%s vs %s)", key.Name, existing.Ref(), key.Ref())
+			}
+			continue
+		}
+		if added.ByName(key.Name) != nil {
+			return 0, fmt.Errorf("duplicate key %s", key.Name)
+		}
+		added = append(added, key)
+	}
+	*keyring = append(*keyring, added...)
+	return len(added), nil
+}
+
 // String implements fmt.Stringer without exposing sensitive data.
 func (keyring Keyring) String() string {
 	var buf strings.Builder
@@ -43,34 +161,250 @@ func (keyring Keyring) String() string {
 	return buf.String()
 }
 
+// Data returns the keyring in the original name=base64 format, unless some
+// keys have types or metadata that only the v2 format can represent.
+// Creation dates are not considered metadata worth upgrading for.
+// Panics if a key has been closed.
 func (keyring Keyring) Data() string {
+	for _, key := range keyring {
+		if key.Type != SymmetricKey || key.Cipher != SecretBox || key.Description != "" || len(key.Scope) > 0 || key.Comment != "" {
+			return keyring.DataV2()
+		}
+	}
+
 	var buf strings.Builder
 	for _, key := range keyring {
 		buf.WriteString(key.Name)
 		buf.WriteByte('=')
-		buf.WriteString(base64.StdEncoding.EncodeToString(key.Data[:]))
+		buf.WriteString(keyring.encodedData(key))
 		buf.WriteByte('\n')
 	}
 	return buf.String()
 }
 
+// DataV2 returns the keyring in the v2 format, which records key types and
+// metadata:
+//
+//	# plainsecrets keyring v2
+//
+//	# comment
+//	[myapp-prod]
+//	type = symmetric
+//	cipher = aes-256-gcm
+//	created = 2023-03-31T12:00:00Z
+//	scope = prod
+//	description = Production secrets
+//	data = <base64>
+//
+// SSH keys have public = <authorized_keys line> and optionally
+// private = <path> instead of data. Panics if a key has been closed.
+func (keyring Keyring) DataV2() string {
+	var buf strings.Builder
+	buf.WriteString(keyringV2Header)
+	buf.WriteString("\n")
+	for _, key := range keyring {
+		buf.WriteString("\n")
+		if key.Comment != "" {
+			for _, line := range strings.Split(key.Comment, "\n") {
+				buf.WriteString(strings.TrimSpace("# " + line))
+				buf.WriteString("\n")
+			}
+		}
+		fmt.Fprintf(&buf, "[%s]\n", key.Name)
+		fmt.Fprintf(&buf, "type = %s\n", key.Type)
+		if key.Cipher != SecretBox {
+			fmt.Fprintf(&buf, "cipher = %s\n", key.Cipher)
+		}
+		if !key.Created.IsZero() {
+			fmt.Fprintf(&buf, "created = %s\n", key.Created.Format(time.RFC3339))
+		}
+		if len(key.Scope) > 0 {
+			fmt.Fprintf(&buf, "scope = %s\n", strings.Join(key.Scope, " "))
+		}
+		if key.Description != "" {
+			fmt.Fprintf(&buf, "description = %s\n", key.Description)
+		}
+		if key.Type == SSHKey {
+			fmt.Fprintf(&buf, "public = %s\n", key.SSHPublicKey)
+			if key.SSHPrivateKeyPath != "" {
+				fmt.Fprintf(&buf, "private = %s\n", key.SSHPrivateKeyPath)
+			}
+		} else {
+			fmt.Fprintf(&buf, "data = %s\n", keyring.encodedData(key))
+		}
+	}
+	return buf.String()
+}
+
+const keyringV2Header = "# plainsecrets keyring v2"
+
+func IsKeyringV2(data string) bool {
+	return strings.HasPrefix(strings.TrimSpace(data), keyringV2Header)
+}
+
 func ParseKeyringFile(path string) (Keyring, error) {
 	raw, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
 
-	keyring, err := ParseKeyringString(string(raw))
+	keyring, err := parseKeyringString(string(raw), path, filepath.Dir(path))
 	if err != nil {
 		return nil, fmt.Errorf("%s: %w", path, err)
 	}
 	return keyring, nil
 }
 
+// ParseKeyringString parses keyring data, asking for a passphrase via
+// Passphrase if the keyring is encrypted.
 func ParseKeyringString(data string) (Keyring, error) {
-	kv, err := parseMultilineKVString(data)
+	return parseKeyringString(data, "keyring", "")
+}
+
+// parseKeyringString resolves relative SSH private key paths against dir,
+// the directory of the keyring file, if set.
+func parseKeyringString(data string, what string, dir string) (Keyring, error) {
+	if IsEncryptedKeyring(data) {
+		passphrase, err := Passphrase(what)
+		if err != nil {
+			return nil, err
+		}
+		data, err = DecryptKeyringString(data, passphrase)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var keyring Keyring
+	var err error
+	if IsKeyringV2(data) {
+		keyring, err = parseKeyringV2(data)
+	} else {
+		keyring, err = parseKeyringV1(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := keyring.resolveDerived(); err != nil {
+		return nil, err
+	}
+	for _, key := range keyring {
+		if key.ssh != nil {
+			key.ssh.dir = dir
+		}
+	}
+	return keyring, nil
+}
+
+func parseKeyringV1(data string) (Keyring, error) {
+	pairs, err := parseKVPairs(data)
 	if err != nil {
 		return nil, err
 	}
-	return ParseKeyringMap(kv)
+	keyring := make(Keyring, 0, len(pairs))
+	for _, p := range pairs {
+		key, err := parseKeyringEntry(p.Key, p.Value)
+		if err != nil {
+			return nil, err
+		}
+		keyring = append(keyring, key)
+	}
+	return keyring, nil
+}
+
+func parseKeyringV2(data string) (Keyring, error) {
+	var keyring Keyring
+	var key *Key
+	var hasData bool
+	var comment []string
+	finish := func() error {
+		if key != nil && !hasData {
+			return fmt.Errorf("%s: missing data", key.Name)
+		}
+		if key != nil && key.Cipher != SecretBox && key.Type != SymmetricKey {
+			return fmt.Errorf("%s: cipher can only be set for symmetric keys", key.Name)
+		}
+		return nil
+	}
+
+	for lno, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line == keyringV2Header {
+			continue
+		} else if line[0] == '#' {
+			comment = append(comment, strings.TrimSpace(line[1:]))
+			continue
+		} else if name, ok := strings.CutPrefix(line, "["); ok {
+			name, ok = strings.CutSuffix(name, "]")
+			if !ok || !IsValidKeyName(name) {
+				return nil, fmt.Errorf("line %d: invalid key header %s", lno+1, line)
+			}
+			if err := finish(); err != nil {
+				return nil, err
+			}
+			if keyring.ByName(name) != nil {
+				return nil, fmt.Errorf("line %d: duplicate key %s", lno+1, name)
+			}
+			key = &Key{Name: name, Comment: strings.Join(comment, "\n")}
+			hasData, comment = false, nil
+			keyring = append(keyring, key)
+			continue
+		}
+
+		attr, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: missing =", lno+1)
+		}
+		if key == nil {
+			return nil, fmt.Errorf("line %d: missing [keyname] header", lno+1)
+		}
+		attr, value = strings.TrimSpace(attr), strings.TrimSpace(value)
+		var err error
+		switch attr {
+		case "type":
+			key.Type, err = ParseKeyType(value)
+		case "cipher":
+			key.Cipher, err = ParseCipher(value)
+		case "created":
+			key.Created, err = time.Parse(time.RFC3339, value)
+		case "scope":
+			key.Scope = strings.Fields(value)
+			for _, env := range key.Scope {
+				if !IsValidEnvNameWildcard(env) {
+					err = fmt.Errorf("malformed env name %q", env)
+				}
+			}
+		case "description":
+			key.Description = value
+		case "public":
+			var k *Key
+			k, err = NewSSHKey(key.Name, value)
+			if k != nil {
+				key.Type, key.Data, key.SSHPublicKey, key.ssh = k.Type, k.Data, k.SSHPublicKey, k.ssh
+			}
+			hasData = true
+		case "private":
+			key.SSHPrivateKeyPath = value
+		case "data":
+			var k *Key
+			if str, ok := strings.CutPrefix(value, "derive:"); ok {
+				k, err = parseDerivation(key.Name, str)
+			} else {
+				k, err = parseKeyData(key.Name, value)
+			}
+			if k != nil {
+				key.Data, key.DeriveFrom, key.DerivePath = k.Data, k.DeriveFrom, k.DerivePath
+			}
+			hasData = true
+		default:
+			err = fmt.Errorf("unknown attribute %s", attr)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lno+1, err)
+		}
+	}
+	if err := finish(); err != nil {
+		return nil, err
+	}
+	return keyring, nil
 }
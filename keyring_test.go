@@ -2,6 +2,8 @@ package plainsecrets
 
 import (
 	_ "embed"
+	"encoding/base64"
+	"strings"
 	"testing"
 )
 
@@ -17,3 +19,192 @@ func TestParseKeyringString(t *testing.T) {
 		t.Errorf("** keyring.String() = %v, wanted %v", a, e)
 	}
 }
+
+func TestEncryptedKeyring(t *testing.T) {
+	keyring := must(ParseKeyringString(sampleKeyring))
+	data := must(keyring.EncryptedData("correct horse"))
+	if !IsEncryptedKeyring(data) {
+		t.Fatalf("** not encrypted: %s", data)
+	}
+
+	t.Setenv(PassphraseEnvVar, "correct horse")
+	decrypted, err := ParseKeyringString(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, e := decrypted.Data(), keyring.Data(); a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+
+	t.Setenv(PassphraseEnvVar, "")
+	_, err = ParseKeyringString(data)
+	if a, e := tostr3("", err), "ERR: keyring is encrypted, set PLAINSECRETS_PASSPHRASE"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+
+	defer func() { PassphrasePrompt = nil }()
+	PassphrasePrompt = func(what string) (string, error) {
+		return "battery staple", nil
+	}
+	_, err = ParseKeyringString(data)
+	if a, e := tostr3("", err), "ERR: wrong passphrase"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+
+	salt := base64.StdEncoding.EncodeToString(make([]byte, saltSize))
+	nonce := base64.StdEncoding.EncodeToString(make([]byte, NonceSize))
+	tests := []struct {
+		params, salt string
+		err          string
+	}{
+		{"1048576:16:1", salt, "unsupported scrypt parameters N=1048576 r=16 p=1 in encrypted keyring, N must be a power of 2 up to 1048576, r up to 32, p up to 16 and N*r up to 8388608"},
+		{"2097152:1:1", salt, "unsupported scrypt parameters N=2097152 r=1 p=1 in encrypted keyring, N must be a power of 2 up to 1048576, r up to 32, p up to 16 and N*r up to 8388608"},
+		{"1000:8:1", salt, "unsupported scrypt parameters N=1000 r=8 p=1 in encrypted keyring, N must be a power of 2 up to 1048576, r up to 32, p up to 16 and N*r up to 8388608"},
+		{"1024:64:1", salt, "unsupported scrypt parameters N=1024 r=64 p=1 in encrypted keyring, N must be a power of 2 up to 1048576, r up to 32, p up to 16 and N*r up to 8388608"},
+		{"1024:8:1000", salt, "unsupported scrypt parameters N=1024 r=8 p=1000 in encrypted keyring, N must be a power of 2 up to 1048576, r up to 32, p up to 16 and N*r up to 8388608"},
+		{"1024:8:1", "", "invalid salt len in encrypted keyring, got 0, wanted 16"},
+		{"1024:8:1", "AAAA", "invalid salt len in encrypted keyring, got 3, wanted 16"},
+		{"1024:8:1", salt, "wrong passphrase"},
+	}
+	for _, tt := range tests {
+		data := encryptedKeyringPrefix + "scrypt:" + tt.params + ":" + tt.salt + ":" + nonce + ":AAAA"
+		if a, e := tostr3(DecryptKeyringString(data, "x")), "ERR: "+tt.err; a != e {
+			t.Errorf("** %s: got %q, wanted %q", tt.params, a, e)
+		}
+	}
+}
+
+func TestKeyringV2(t *testing.T) {
+	input := "# plainsecrets keyring v2\n\n# Production key,\n# ask ops before rotating\n[myapp-prod]\ntype = symmetric\ncreated = 2023-03-31T12:00:00Z\nscope = prod\ndescription = Production secrets\ndata = rTYS3+vPf0XfCPW4tCykpQoqcxMyiciNLaDlj+VSuQU=\n\n[alice]\ntype = box-private\ndata = 5OnO+jqOo/hhz1DVJox3TpaefmbwFqbiw6HYfuogz+Y=\n"
+	keyring, err := ParseKeyringString(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, e := keyring.Data(), input; a != e {
+		t.Errorf("** Data() = %q, wanted %q", a, e)
+	}
+
+	plain := must(ParseKeyringString(sampleKeyring))
+	if a, e := plain.Data(), sampleKeyring; a != e {
+		t.Errorf("** v1 Data() = %q, wanted %q", a, e)
+	}
+	if a, e := must(ParseKeyringString(plain.DataV2())).Data(), sampleKeyring; a != e {
+		t.Errorf("** upgraded Data() = %q, wanted %q", a, e)
+	}
+
+	vals := must(ParseString("@all = prod stag dev\n@nonprod = ! prod"))
+	if a, e := tostr3(vals.EncryptValue("x", "nonprod", "myapp-prod", keyring)), "ERR: key myapp-prod is limited to prod, cannot encrypt for nonprod"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+
+	pub := keyring.ByName("alice").PublicKey()
+	rhs := must(vals.EncryptValue("hello", "dev", "alice", Keyring{pub}))
+	vals = must(ParseString("@all = dev\nX = " + rhs))
+	if a, e := tostr3(vals.Value("X", "dev", Keyring{pub})), "ERR: X: key alice is a public key, cannot decrypt"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+	if a, e := tostr3(vals.Value("X", "dev", keyring)), "hello"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+}
+
+func TestKeyFingerprints(t *testing.T) {
+	keyring := must(ParseKeyringString(sampleKeyring))
+	other := Keyring{NewKey("myapp-prod")}
+	alice, bob := NewBoxKey("alice"), NewBoxKey("alice")
+	if a, e := alice.PublicKey().Fingerprint(), alice.Fingerprint(); a != e {
+		t.Errorf("** public key fingerprint %q, wanted %q", a, e)
+	}
+	if alice.Fingerprint() == bob.Fingerprint() {
+		t.Errorf("** different keys share fingerprint %q", alice.Fingerprint())
+	}
+
+	vals := must(ParseString("@all = prod"))
+	vals.Fingerprints = true
+	fp := keyring.ByName("myapp-prod").Fingerprint()
+	rhs := must(vals.EncryptValue("hello", "prod", "myapp-prod", keyring))
+	if !strings.HasPrefix(rhs, "secret:myapp-prod#"+fp+":") {
+		t.Fatalf("** got %q, wanted fingerprint %s", rhs, fp)
+	}
+
+	vals = must(ParseString("@all = prod\nX = " + rhs))
+	if a, e := tostr3(vals.Value("X", "prod", keyring)), "hello"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+	if a, e := tostr3(vals.Value("X", "prod", other)), "ERR: X: this was encrypted with myapp-prod#"+fp+", your keyring has "+other[0].Ref(); a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+
+	vals = must(ParseString("@all = prod\nX = " + strings.Replace(rhs, "#"+fp, "", 1)))
+	if a, e := tostr3(vals.Value("X", "prod", other)), "ERR: X: decryption failed, your keyring has "+other[0].Ref(); a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+
+	if _, err := ParseString("@all = prod\nX = secret:myapp-prod#xyz:" + strings.SplitN(rhs, ":", 3)[2]); err == nil {
+		t.Errorf("** malformed fingerprint accepted")
+	}
+}
+
+func TestDerivedKeys(t *testing.T) {
+	input := "master=rTYS3+vPf0XfCPW4tCykpQoqcxMyiciNLaDlj+VSuQU=\nmyapp-prod=derive:master:myapp/prod\nmyapp-dev=derive:myapp-prod:dev\n"
+	keyring := must(ParseKeyringString(input))
+	if a, e := keyring.Data(), input; a != e {
+		t.Errorf("** Data() = %q, wanted %q", a, e)
+	}
+	prod := keyring.ByName("myapp-prod")
+	if prod.Data == keyring.ByName("master").Data || prod.Data == keyring.ByName("myapp-dev").Data {
+		t.Errorf("** derived key data not distinct")
+	}
+	if a, e := must(NewDerivedKey("x", keyring.ByName("master"), "myapp/prod")).Data, prod.Data; a != e {
+		t.Errorf("** NewDerivedKey = %x, wanted %x", a, e)
+	}
+
+	v2 := must(ParseKeyringString(keyring.DataV2()))
+	if a, e := v2.ByName("myapp-dev").Data, keyring.ByName("myapp-dev").Data; a != e {
+		t.Errorf("** v2 data = %x, wanted %x", a, e)
+	}
+
+	// without the master, the subkey is written out as is
+	exported := Keyring{prod}
+	if a, e := must(ParseKeyringString(exported.Data())).ByName("myapp-prod").Data, prod.Data; a != e {
+		t.Errorf("** exported data = %x, wanted %x", a, e)
+	}
+
+	keyring.Rename("master", "root")
+	if a, e := strings.Split(keyring.Data(), "\n")[1], "myapp-prod=derive:root:myapp/prod"; a != e {
+		t.Errorf("** after rename got %q, wanted %q", a, e)
+	}
+
+	for _, bad := range []string{"a=derive:missing:x\n", "a=derive:b:x\nb=derive:a:y\n", "a=derive:b\n"} {
+		if _, err := ParseKeyringString(bad); err == nil {
+			t.Errorf("** %q: no error", bad)
+		}
+	}
+
+	// public keys cannot be masters
+	pub := NewBoxKey("alice").PublicKey()
+	_, err := NewDerivedKey("x", pub, "x")
+	if a, e := tostr3("", err), "ERR: cannot derive keys from alice, which is a box-public key"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+	_, err = ParseKeyringString(Keyring{pub}.DataV2() + "\n[x]\ntype = symmetric\ndata = derive:alice:x\n")
+	if a, e := tostr3("", err), "ERR: x: cannot derive keys from alice, which is a box-public key"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+}
+
+func TestKeyTypeString(t *testing.T) {
+	for _, tt := range []struct {
+		kt KeyType
+		e  string
+	}{
+		{SymmetricKey, "symmetric"},
+		{SSHKey, "ssh-ed25519"},
+		{KeyType(42), "KeyType(42)"},
+		{KeyType(-1), "KeyType(-1)"},
+	} {
+		if a := tt.kt.String(); a != tt.e {
+			t.Errorf("** got %q, wanted %q", a, tt.e)
+		}
+	}
+}
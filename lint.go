@@ -0,0 +1,43 @@
+package plainsecrets
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Lint returns variants that can be cleaned up, with Err describing why.
+func (vals *Values) Lint() []*Variant {
+	now := vals.now()
+	var result []*Variant
+	for name, entries := range vals.entries {
+		for _, e := range entries {
+			if e.ActiveFrom.After(now) {
+				continue
+			}
+			var newer *entry
+			var hasOlder bool
+			for _, peer := range entries {
+				if peer == e || peer.Env != e.Env || peer.Generation != e.Generation || peer.ActiveFrom.After(now) {
+					continue
+				}
+				if peer.ActiveFrom.After(e.ActiveFrom) {
+					if newer == nil || peer.ActiveFrom.After(newer.ActiveFrom) {
+						newer = peer
+					}
+				} else {
+					hasOlder = true
+				}
+			}
+			if newer != nil {
+				result = append(result, e.variant(name, "", fmt.Errorf("superseded by %s since %s, can be removed", newer.RawLHS, newer.ActiveFrom.Format(time.RFC3339))))
+			} else if !e.ActiveFrom.IsZero() && !hasOlder {
+				result = append(result, e.variant(name, "", fmt.Errorf("activation time has passed, the @ suffix can be removed")))
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].RawLHS < result[j].RawLHS
+	})
+	return result
+}
@@ -0,0 +1,227 @@
+package plainsecrets
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PaddingSetting is the name of the optional setting in the secrets file
+// (like DEFAULT_KEY) that pads encrypted values to a multiple of this many
+// bytes, e.g. PADDING = 64, so that the ciphertext does not reveal the exact
+// length of the value.
+const PaddingSetting = "PADDING"
+
+// paddedSuffix marks values that have padding after the plaintext: a 0x80
+// byte followed by zero bytes, which is stripped unambiguously on decryption.
+const paddedSuffix = ":padded"
+
+// paddedMagic starts padded plaintexts. The suffix is outside of the
+// ciphertext, so this is what authenticates the padding: a padded value
+// stripped of its suffix fails to decrypt instead of gaining trailing bytes.
+const paddedMagic = "\x00pad"
+
+// padding returns the padding block size for the env, 0 for no padding.
+func (vals *Values) padding(env string) (int, error) {
+	if vals.Padding != 0 {
+		return vals.Padding, nil
+	}
+	if env == "" {
+		return 0, nil
+	}
+	str, err := vals.Value(PaddingSetting, env, nil)
+	if err != nil || str == "" {
+		return 0, err
+	}
+	n, err := strconv.Atoi(str)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q for env %s", PaddingSetting, str, env)
+	}
+	return n, nil
+}
+
+// pad prepends paddedMagic and appends 0x80 and zero bytes up to a multiple
+// of the block size.
+func pad(plaintext []byte, block int) []byte {
+	m := len(paddedMagic) + len(plaintext)
+	padded := make([]byte, (m/block+1)*block)
+	copy(padded, paddedMagic)
+	copy(padded[len(paddedMagic):], plaintext)
+	padded[m] = 0x80
+	return padded
+}
+
+// unpadPlaintext strips the padding of a decrypted value, checking that it
+// matches the :padded suffix.
+func unpadPlaintext(raw []byte, padded bool) ([]byte, error) {
+	if rest, ok := bytes.CutPrefix(raw, []byte(paddedMagic)); ok {
+		if !padded {
+			return nil, fmt.Errorf("padded value is missing the %s suffix", paddedSuffix)
+		}
+		return unpad(rest)
+	} else if padded {
+		return nil, fmt.Errorf("invalid padding")
+	}
+	return raw, nil
+}
+
+func unpad(padded []byte) ([]byte, error) {
+	for i := len(padded) - 1; i >= 0; i-- {
+		switch padded[i] {
+		case 0:
+			continue
+		case 0x80:
+			return padded[:i], nil
+		}
+		break
+	}
+	return nil, fmt.Errorf("invalid padding")
+}
+
+// cutPaddedSuffix strips the :padded option off a secret: or envelope: value.
+func cutPaddedSuffix(str string) (string, bool) {
+	return strings.CutSuffix(str, paddedSuffix)
+}
+
+// RepadAllInString re-encrypts secret: and envelope: values whose padding
+// does not match the current PADDING setting (or Values.Padding), keeping
+// their keys. Envelopes keep their recipients and data key.
+func (vals *Values) RepadAllInString(data string, keyring KeyProvider) (string, int, []*Variant) {
+	pairs, _ := parseKVPairs(data)
+	pairsByKey := make(map[string]*kvPair, len(pairs))
+	for _, p := range pairs {
+		pairsByKey[p.Key] = p
+	}
+
+	lines := strings.Split(data, "\n")
+	var edits []lineEdit
+	var failed []*Variant
+	for _, name := range vals.Names() {
+		for _, e := range vals.entries[name] {
+			if e.Encoding != Encrypted && e.Encoding != Envelope {
+				continue
+			}
+			p := pairsByKey[e.RawLHS]
+			if p == nil || p.Value != e.RawRHS {
+				continue
+			}
+			rhs, err := vals.repad(name, e, lhsEnv(e.RawLHS), keyring)
+			if err != nil {
+				failed = append(failed, e.variant(name, "", err))
+				continue
+			} else if rhs == "" {
+				continue
+			}
+			edits = append(edits, lineEdit{p.Line, p.EndLine, []string{lhsPrefix(lines[p.Line-1]) + rhs}})
+		}
+	}
+
+	return strings.Join(applyLineEdits(lines, edits), "\n"), len(edits), failed
+}
+
+func (vals *Values) RepadAllInFile(path string, keyring KeyProvider) (int, []*Variant, error) {
+	var failed []*Variant
+	n, err := updateFile(path, func(data string) (string, int, error) {
+		data, n, f := vals.RepadAllInString(data, keyring)
+		failed = f
+		return data, n, nil
+	})
+	return n, failed, err
+}
+
+// repad returns the re-encrypted RHS of the entry, or "" if its padding is
+// already right.
+func (vals *Values) repad(name string, e *entry, env string, keyring KeyProvider) (string, error) {
+	block, err := vals.padding(env)
+	if err != nil {
+		return "", err
+	}
+	raw, keyName, err := e.decrypt(keyring)
+	vals.audit(name, env, e, keyName, err)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := unpadPlaintext(raw, e.Padded)
+	if err != nil {
+		return "", err
+	}
+	if block == 0 && !e.Padded || block > 0 && e.Padded && bytes.HasPrefix(raw, []byte(paddedMagic)) && len(raw) == len(pad(plaintext, block)) {
+		return "", nil
+	}
+
+	if e.Encoding == Envelope {
+		dataKey, _, err := e.unwrapDataKey(keyring)
+		if err != nil {
+			return "", err
+		}
+		repadded := *e
+		repadded.Nonce, repadded.Ciphertext, repadded.Padded, err = vals.sealPadded(&Key{Data: *dataKey}, plaintext, env)
+		if err != nil {
+			return "", err
+		}
+		return repadded.envelopeRHS(), nil
+	}
+
+	// keep the key and fingerprint the value already uses; the key only has
+	// to cover the envs the entry is actually in effect for
+	key, err := lookupKey(keyring, e.KeyName)
+	if err != nil {
+		return "", err
+	}
+	envs, err := vals.effectiveEnvs(name, e)
+	if err != nil {
+		return "", err
+	}
+	for _, env := range envs {
+		if err := vals.checkKeyScope(key, env); err != nil {
+			return "", err
+		}
+	}
+	nonce, ciphertext, padded, err := vals.sealPadded(key, plaintext, env)
+	if err != nil {
+		return "", err
+	}
+	rhs := fmt.Sprintf("secret:%s%s:%s:%s", cipherPrefix(key.Cipher), e.keyRef(), base64.StdEncoding.EncodeToString(nonce), base64.StdEncoding.EncodeToString(ciphertext))
+	if padded {
+		rhs += paddedSuffix
+	}
+	return rhs, nil
+}
+
+// effectiveEnvs returns the envs for which the entry is the variant in
+// effect, i.e. not overridden by a more specific one. Wildcard patterns are
+// returned as is.
+func (vals *Values) effectiveEnvs(name string, e *entry) ([]string, error) {
+	at := vals.now()
+	if e.ActiveFrom.After(at) {
+		at = e.ActiveFrom
+	}
+	var result []string
+	for _, env := range e.Resolved.included {
+		if !IsWildcard(env) {
+			best, err := vals.pickVariantAt(name, env, vals.entries[name], e.Generation, at)
+			if err != nil {
+				return nil, err
+			} else if best != e {
+				continue
+			}
+		}
+		result = append(result, env)
+	}
+	return result, nil
+}
+
+// sealPadded is seal with padding according to the env's settings.
+func (vals *Values) sealPadded(key *Key, plaintext []byte, env string) (nonce, ciphertext []byte, padded bool, err error) {
+	block, err := vals.padding(env)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if block > 0 {
+		plaintext = pad(plaintext, block)
+	}
+	nonce, ciphertext, err = seal(key, plaintext)
+	return nonce, ciphertext, block > 0, err
+}
@@ -0,0 +1,87 @@
+package plainsecrets
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestPadding(t *testing.T) {
+	keyring := must(ParseKeyringString(sampleKeyring))
+	ops := NewBoxKey("ops")
+	keyring.Add(ops)
+
+	vals := must(ParseString("@all = prod dev\nPADDING.prod = 32\nPADDING.dev = 0"))
+	ciphertextLen := func(rhs string) int {
+		comps := strings.Split(rhs, ":")
+		return len(must(base64.StdEncoding.DecodeString(comps[3])))
+	}
+	short := must(vals.EncryptValue("1234567890123", "prod", "myapp-prod", keyring))
+	long := must(vals.EncryptValue("12345678901234567890", "prod", "myapp-prod", keyring))
+	if !strings.HasSuffix(short, ":padded") || ciphertextLen(short) != ciphertextLen(long) {
+		t.Errorf("** got %q and %q, wanted equal lengths", short, long)
+	}
+	if a := must(vals.EncryptValue("1234567890123", "dev", "myapp-dev", keyring)); strings.HasSuffix(a, ":padded") {
+		t.Errorf("** got %q, wanted no padding for dev", a)
+	}
+
+	input := "@all = prod dev\nPADDING = 0\nA.prod = " + short + "\nA.dev = " + must(vals.EncryptValue("x", "dev", "myapp-dev", keyring)) + "\nE = " + must(vals.EncryptValue("\x80\x00", "prod", "myapp-prod,ops", keyring)) + "\n"
+	vals = must(ParseString(input))
+	if a, e := tostr3(vals.Value("A", "prod", keyring)), "1234567890123"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+	if a, e := tostr3(vals.Value("E", "dev", keyring)), "\x80\x00"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+
+	// PADDING = 0 un-pads A.prod and E; then Padding = 16 pads everything it can decrypt
+	output, n, failed := vals.RepadAllInString(input, keyring)
+	if n != 2 || len(failed) > 0 || strings.Contains(output, ":padded") {
+		t.Fatalf("** repadded %d, failed %v:\n%s", n, failed, output)
+	}
+	vals = must(ParseString(output))
+	vals.Padding = 16
+	output, n, failed = vals.RepadAllInString(output, Keyring{keyring.ByName("myapp-dev"), ops})
+	if n != 2 || len(failed) != 1 || strings.Count(output, ":padded") != 2 {
+		t.Fatalf("** repadded %d, failed %v:\n%s", n, failed, output)
+	}
+	vals = must(ParseString(output))
+	for _, c := range []struct{ name, env, value string }{{"A", "dev", "x"}, {"E", "prod", "\x80\x00"}} {
+		if a, e := tostr3(vals.Value(c.name, c.env, keyring)), c.value; a != e {
+			t.Errorf("** %s.%s = %q, wanted %q", c.name, c.env, a, e)
+		}
+	}
+
+	// the padding is authenticated, so dropping the suffix is detected
+	vals = must(ParseString("@all = prod\nA = " + strings.TrimSuffix(short, ":padded")))
+	if a, e := tostr3(vals.Value("A", "prod", keyring)), "ERR: A: padded value is missing the :padded suffix"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+
+	// padded values must carry the marker
+	nonce, ciphertext, err := seal(keyring.ByName("myapp-prod"), append([]byte("nomarker"), 0x80, 0, 0, 0, 0, 0, 0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vals = must(ParseString("@all = prod\nA = secret:myapp-prod:" + base64.StdEncoding.EncodeToString(nonce) + ":" + base64.StdEncoding.EncodeToString(ciphertext) + ":padded\n"))
+	if a, e := tostr3(vals.Value("A", "prod", keyring)), "ERR: A: invalid padding"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+
+	// repadding keeps the key ref, and a scoped key only has to cover the
+	// envs the entry is in effect for
+	scoped := NewKey("scoped")
+	scoped.Scope = []string{"prod"}
+	keyring.Add(scoped)
+	vals = must(ParseString("@all = prod dev"))
+	vals.Fingerprints = true
+	rhs := must(vals.EncryptValue("x", "prod", "scoped", keyring))
+	input = "@all = prod dev\nPADDING = 16\nA = " + rhs + "\nA.dev = y\n"
+	output, n, failed = must(ParseString(input)).RepadAllInString(input, keyring)
+	if n != 1 || len(failed) > 0 || !strings.Contains(output, "A = secret:"+scoped.Ref()+":") || !strings.HasSuffix(output, ":padded\nA.dev = y\n") {
+		t.Fatalf("** repadded %d, failed %v:\n%s", n, failed, output)
+	}
+	if a, e := tostr3(must(ParseString(output)).Value("A", "prod", keyring)), "x"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+}
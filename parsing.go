@@ -1,30 +1,63 @@
 package plainsecrets
 
 import (
+	"crypto/sha256"
 	"encoding/base64"
 	"fmt"
 	"os"
+	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
 	keyNameCharset    = "a-zA-Z0-9_.@-"
+	derivePathCharset = "a-zA-Z0-9_.@/-"
 	envNameCharset    = "a-zA-Z0-9_-"
 	secretNameCharset = "a-zA-Z0-9_"
 )
 
 var (
 	keyNameRe        = regexp.MustCompile("^[" + keyNameCharset + "]+$")
+	derivePathRe     = regexp.MustCompile("^[" + derivePathCharset + "]+$")
 	secretNameRe     = regexp.MustCompile("^[" + secretNameCharset + "]+$")
 	secretWildcardRe = regexp.MustCompile("^[*" + secretNameCharset + "]+$")
 	envNameRe        = regexp.MustCompile("^[" + envNameCharset + "]+$")
 	envWildcardRe    = regexp.MustCompile("^[*" + envNameCharset + "]+$")
 )
 
+// parseKeyRef splits name#fingerprint, the fingerprint is optional.
+func parseKeyRef(ref string) (name, fingerprint string, ok bool) {
+	name, fingerprint, found := strings.Cut(ref, "#")
+	if !IsValidKeyName(name) {
+		return name, "", false
+	}
+	if found && !isFingerprint(fingerprint) {
+		return name, fingerprint, false
+	}
+	return name, fingerprint, true
+}
+
+func isFingerprint(s string) bool {
+	if len(s) != FingerprintLen {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
 func IsValidKeyName(str string) bool {
 	return keyNameRe.MatchString(str)
 }
+func IsValidDerivePath(str string) bool {
+	return derivePathRe.MatchString(str)
+}
 func IsValidValueName(str string) bool {
 	return secretNameRe.MatchString(str)
 }
@@ -45,23 +78,38 @@ func IsWildcard(env string) bool {
 func ParseKeyringMap(kv map[string]string) (Keyring, error) {
 	keyring := make(Keyring, 0, len(kv))
 	for name, v := range kv {
-		if !IsValidKeyName(name) {
-			return nil, fmt.Errorf("invalid key name %q, must be [%s]+", name, keyNameCharset)
-		}
-		keyData, err := base64.StdEncoding.DecodeString(v)
+		key, err := parseKeyringEntry(name, v)
 		if err != nil {
-			return nil, fmt.Errorf("%s: invalid base64-encoded key: %w", name, err)
-		}
-		if len(keyData) != KeySize {
-			return nil, fmt.Errorf("%s: invalid key size %d, wanted %d", name, len(keyData), KeySize)
+			return nil, err
 		}
-		key := &Key{Name: name}
-		copy(key.Data[:], keyData)
 		keyring = append(keyring, key)
 	}
+	if err := keyring.resolveDerived(); err != nil {
+		return nil, err
+	}
 	return keyring, nil
 }
 
+func parseKeyringEntry(name, v string) (*Key, error) {
+	if !IsValidKeyName(name) {
+		return nil, fmt.Errorf("invalid key name %q, must be [%s]+", name, keyNameCharset)
+	}
+	if str, ok := strings.CutPrefix(v, "derive:"); ok {
+		return parseDerivation(name, str)
+	}
+	return parseKeyData(name, v)
+}
+
+// parseDerivation parses the master:path part of derive:master:path, the key
+// data is filled in by Keyring.resolveDerived.
+func parseDerivation(name, str string) (*Key, error) {
+	master, path, ok := strings.Cut(str, ":")
+	if !ok || !IsValidKeyName(master) || !IsValidDerivePath(path) {
+		return nil, fmt.Errorf(`%s: invalid derived key, expected "derive:<master>:<path>"`, name)
+	}
+	return &Key{Name: name, DeriveFrom: master, DerivePath: path}, nil
+}
+
 func ParseFile(path string) (*Values, error) {
 	vals := New()
 	err := vals.ParseFile(path)
@@ -80,6 +128,7 @@ func (vals *Values) ParseFile(path string) error {
 		return err
 	}
 
+	vals.dir = filepath.Dir(path)
 	err = vals.ParseString(string(raw))
 	if err != nil {
 		return fmt.Errorf("%s: %w", path, err)
@@ -96,8 +145,18 @@ func (vals *Values) ParseString(data string) error {
 }
 
 func (vals *Values) ParseMap(values map[string]string) error {
+	if err := vals.parseSignature(values); err != nil {
+		return err
+	}
+	if vals.VerifyKey != nil {
+		if err := vals.Verify(vals.VerifyKey, vals.MinSerial); err != nil {
+			return err
+		}
+	}
 	for lhs, rhs := range values {
-		if groupName, ok := strings.CutPrefix(lhs, "@"); ok {
+		if strings.HasPrefix(lhs, "!") {
+			continue
+		} else if groupName, ok := strings.CutPrefix(lhs, "@"); ok {
 			g, err := parseEnvGroup(groupName, rhs)
 			if err != nil {
 				return fmt.Errorf("%w in %q", err, lhs+"="+rhs)
@@ -108,7 +167,9 @@ func (vals *Values) ParseMap(values map[string]string) error {
 			vals.envs[groupName] = g
 
 		} else {
-			name, env, envFound := strings.Cut(lhs, ".")
+			spec, timeStr, scheduled := strings.Cut(lhs, "@")
+			spec, genStr, hasGen := strings.Cut(spec, "~")
+			name, env, envFound := strings.Cut(spec, ".")
 			if envFound {
 				if !IsValidEnvName(env) {
 					return fmt.Errorf("malformed env name %q in %q", env, lhs+"="+rhs)
@@ -119,14 +180,43 @@ func (vals *Values) ParseMap(values map[string]string) error {
 			if !IsValidValueName(name) {
 				return fmt.Errorf("malformed value name %q in %q", env, lhs+"="+rhs)
 			}
+			var generation int
+			if hasGen {
+				var err error
+				generation, err = strconv.Atoi(genStr)
+				if err != nil || generation <= 0 {
+					return fmt.Errorf("malformed generation %q in %q", genStr, lhs+"="+rhs)
+				}
+			}
+			var activeFrom time.Time
+			if scheduled {
+				var err error
+				activeFrom, err = parseActivationTime(timeStr)
+				if err != nil {
+					return fmt.Errorf("%w in %q", err, lhs+"="+rhs)
+				}
+			}
 			e := &entry{
-				Env:    env,
-				RawLHS: lhs,
-				RawRHS: rhs,
+				Env:        env,
+				ActiveFrom: activeFrom,
+				Generation: generation,
+				RawLHS:     lhs,
+				RawRHS:     rhs,
 			}
 			if err := parseValue(rhs, e); err != nil {
 				return fmt.Errorf("%w in %q", err, lhs+"="+rhs)
 			}
+			if vals.VerifyKey != nil && (e.Encoding == File || e.Encoding == ToBeEncryptedFile) {
+				// the signature covers the path but not the file contents
+				return fmt.Errorf("file contents are not covered by the signature, use encfile: to encrypt them into a secretfile: in %q", lhs+"="+rhs)
+			}
+			if e.Path != "" {
+				path, err := vals.resolvePath(e.Path)
+				if err != nil {
+					return fmt.Errorf("%w in %q", err, lhs+"="+rhs)
+				}
+				e.Path = path
+			}
 			vals.entries[name] = append(vals.entries[name], e)
 		}
 	}
@@ -134,6 +224,47 @@ func (vals *Values) ParseMap(values map[string]string) error {
 	return vals.rebuild()
 }
 
+var activationTimeLayouts = []string{
+	"2006-01-02T15:04Z07:00",
+	time.RFC3339,
+	"2006-01-02",
+}
+
+func parseActivationTime(str string) (time.Time, error) {
+	for _, layout := range activationTimeLayouts {
+		if t, err := time.Parse(layout, str); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("malformed activation time %q, expected 2006-01-02T15:04Z or 2006-01-02", str)
+}
+
+// lhsEnv returns the env of NAME.env~1@time, or All if not specified.
+// lhsName returns the NAME part of NAME.env~1@time.
+func lhsName(lhs string) string {
+	if i := strings.IndexAny(lhs, ".~@"); i >= 0 {
+		return lhs[:i]
+	}
+	return lhs
+}
+
+func lhsEnv(lhs string) string {
+	spec, _, _ := strings.Cut(lhs, "@")
+	spec, _, _ = strings.Cut(spec, "~")
+	if _, env, found := strings.Cut(spec, "."); found {
+		return env
+	}
+	return All
+}
+
+// lhsSuffix returns the ~generation and @time part of NAME.env~1@time.
+func lhsSuffix(lhs string) string {
+	if i := strings.IndexAny(lhs, "~@"); i >= 0 {
+		return lhs[i:]
+	}
+	return ""
+}
+
 func parseEnvList(str string) (negated bool, items []string, err error) {
 	if s, ok := strings.CutPrefix(str, "!"); ok {
 		negated = true
@@ -164,34 +295,61 @@ func parseEnvGroup(groupName string, listStr string) (*envGroup, error) {
 }
 
 func parseValue(str string, e *entry) error {
+	var err error
+	if s, quoted, err := unquote(str); err != nil {
+		return err
+	} else if quoted {
+		e.Encoding = Plain
+		e.PlainValue = s
+		return nil
+	}
+
 	if str, ok := strings.CutPrefix(str, "enc:"); ok {
 		e.Encoding = ToBeEncrypted
 		keyName, str, ok := strings.Cut(str, ":")
 		if !ok {
 			return fmt.Errorf(`missing another colon, expected "enc::<value>" or "enc:<keyname>:<value>"`)
 		}
-		if keyName != "" && !IsValidKeyName(keyName) {
-			return fmt.Errorf(`invalid key name %q in "enc:<keyname>:<value>"`, keyName)
+		if keyName != "" {
+			for _, name := range strings.Split(keyName, ",") {
+				if !IsValidKeyName(name) {
+					return fmt.Errorf(`invalid key name %q in "enc:<keyname>[,<keyname>...]:<value>"`, name)
+				}
+			}
+		}
+		e.PlainValue, _, err = unquote(str)
+		if err != nil {
+			return err
 		}
-		e.PlainValue = str
 		e.KeyName = keyName
 	} else if str, ok := strings.CutPrefix(str, "secret:"); ok {
+		str, e.Padded = cutPaddedSuffix(str)
 		comps := strings.Split(str, ":")
-		if len(comps) != 3 {
-			return fmt.Errorf(`invalid secret value, expected "secret:<keyname>:<nonce>:<ciphertext>"`)
+		if len(comps) == 5 && comps[0] == "v2" {
+			// secret:v2:<cipher>:<keyname>:<nonce>:<ciphertext>
+			e.Cipher, err = ParseCipher(comps[1])
+			if err != nil {
+				return err
+			}
+			comps = comps[2:]
+		} else if len(comps) != 3 {
+			return fmt.Errorf(`invalid secret value, expected "secret:<keyname>:<nonce>:<ciphertext>" or "secret:v2:<cipher>:<keyname>:<nonce>:<ciphertext>"`)
 		}
-		keyName, nonceStr, ciphertextStr := comps[0], comps[1], comps[2]
+		keyRef, nonceStr, ciphertextStr := comps[0], comps[1], comps[2]
 
-		if !IsValidKeyName(keyName) {
-			return fmt.Errorf(`invalid key name %q in "secret:<keyname>:<nonce>:<ciphertext>"`, keyName)
+		keyName, fingerprint, ok := parseKeyRef(keyRef)
+		if !ok {
+			return fmt.Errorf(`invalid key name %q in "secret:<keyname>:<nonce>:<ciphertext>"`, keyRef)
 		}
 
 		nonce, err := base64.StdEncoding.DecodeString(nonceStr)
 		if err != nil {
 			return fmt.Errorf(`invalid nonce in "secret:<keyname>:<nonce>:<ciphertext>": %w`, err)
 		}
-		if len(nonce) != NonceSize {
-			return fmt.Errorf(`invalid nonce len in "secret:<keyname>:<nonce>:<ciphertext>", got %d, wanted %d`, len(nonce), NonceSize)
+		if len(nonce) == 0 {
+			nonce = nil // sealed box
+		} else if len(nonce) != e.Cipher.NonceSize() {
+			return fmt.Errorf(`invalid nonce len in "secret:<keyname>:<nonce>:<ciphertext>", got %d, wanted %d`, len(nonce), e.Cipher.NonceSize())
 		}
 
 		ciphertext, err := base64.StdEncoding.DecodeString(ciphertextStr)
@@ -201,11 +359,86 @@ func parseValue(str string, e *entry) error {
 
 		e.Encoding = Encrypted
 		e.KeyName = keyName
-		copy(e.Nonce[:], nonce)
+		e.KeyFingerprint = fingerprint
+		e.Nonce = nonce
 		e.Ciphertext = ciphertext
+	} else if str, ok := strings.CutPrefix(str, "envelope:"); ok {
+		str, e.Padded = cutPaddedSuffix(str)
+		if err := parseEnvelope(str, e); err != nil {
+			return err
+		}
+	} else if str, ok := strings.CutPrefix(str, "file:"); ok {
+		if str == "" {
+			return fmt.Errorf(`missing path in "file:<path>"`)
+		}
+		e.Encoding = File
+		e.PlainValue = str
+		e.Path = str
+	} else if str, ok := strings.CutPrefix(str, "encfile:"); ok {
+		keyName, str, ok := strings.Cut(str, ":")
+		if !ok {
+			return fmt.Errorf(`missing another colon, expected "encfile::<path>" or "encfile:<keyname>:<path>"`)
+		}
+		if keyName != "" && !IsValidKeyName(keyName) {
+			return fmt.Errorf(`invalid key name %q in "encfile:<keyname>:<path>"`, keyName)
+		}
+		if str == "" {
+			return fmt.Errorf(`missing path in "encfile:<keyname>:<path>"`)
+		}
+		e.Encoding = ToBeEncryptedFile
+		e.KeyName = keyName
+		e.PlainValue = str
+		e.Path = str
+	} else if str, ok := strings.CutPrefix(str, "secretfile:"); ok {
+		// the key ref comes first and the digest last, so that the path may
+		// contain colons
+		if rest, ok := strings.CutPrefix(str, "v2:"); ok {
+			// secretfile:v2:<cipher>:<keyname>:<path>:<digest>
+			cipherName, rest, _ := strings.Cut(rest, ":")
+			if cipher, err := ParseCipher(cipherName); err == nil {
+				e.Cipher, str = cipher, rest
+			}
+		}
+		keyRef, str, ok := strings.Cut(str, ":")
+		i := strings.LastIndexByte(str, ':')
+		if !ok || i < 0 {
+			return fmt.Errorf(`invalid secret file value, expected "secretfile:<keyname>:<path>:<digest>" or "secretfile:v2:<cipher>:<keyname>:<path>:<digest>"`)
+		}
+		path, digestStr := str[:i], str[i+1:]
+
+		keyName, fingerprint, ok := parseKeyRef(keyRef)
+		if !ok {
+			return fmt.Errorf(`invalid key name %q in "secretfile:<keyname>:<path>:<digest>"`, keyRef)
+		}
+		if path == "" {
+			return fmt.Errorf(`missing path in "secretfile:<keyname>:<path>:<digest>"`)
+		}
+		digest, err := base64.StdEncoding.DecodeString(digestStr)
+		if err != nil {
+			return fmt.Errorf(`invalid digest in "secretfile:<keyname>:<path>:<digest>": %w`, err)
+		}
+		if len(digest) != sha256.Size {
+			return fmt.Errorf(`invalid digest len in "secretfile:<keyname>:<path>:<digest>", got %d, wanted %d`, len(digest), sha256.Size)
+		}
+
+		e.Encoding = EncryptedFile
+		e.KeyName = keyName
+		e.KeyFingerprint = fingerprint
+		e.PlainValue = path
+		e.Path = path
+		copy(e.Digest[:], digest)
+	} else if str, ok := strings.CutPrefix(str, "gen:"); ok {
+		if err := validateGenSpec(str); err != nil {
+			return err
+		}
+		e.Encoding = ToBeGenerated
+		e.PlainValue = str
 	} else if str, ok := strings.CutPrefix(str, "TODO:"); ok {
 		e.Encoding = Placeholder
-		e.PlainValue = str
+		e.PlainValue, _, err = unquote(str)
+		if err != nil {
+			return err
+		}
 	} else if str == "TODO" {
 		e.Encoding = Placeholder
 	} else if str == "NONE" || str == "none" {
@@ -216,3 +449,11 @@ func parseValue(str string, e *entry) error {
 	}
 	return nil
 }
+
+// isReservedValue returns whether str would not be read back as a plain
+// value when written unquoted.
+func isReservedValue(str string) bool {
+	var e entry
+	err := parseValue(str, &e)
+	return err != nil || e.Encoding != Plain || e.PlainValue != str
+}
@@ -0,0 +1,137 @@
+package plainsecrets
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	PassphraseEnvVar = "PLAINSECRETS_PASSPHRASE"
+
+	encryptedKeyringPrefix = "plainsecrets-keyring:"
+	scryptN                = 1 << 15
+	scryptR                = 8
+	scryptP                = 1
+	saltSize               = 16
+
+	// limits on the scrypt parameters of keyrings being decrypted, so that a
+	// crafted keyring cannot make us allocate gigabytes or spin for hours
+	maxScryptN  = 1 << 20
+	maxScryptR  = 32
+	maxScryptP  = 16
+	maxScryptNR = 1 << 23 // 1 GiB of memory
+)
+
+// PassphrasePrompt is called to obtain the passphrase of an encrypted
+// keyring when PLAINSECRETS_PASSPHRASE is not set. what describes the
+// keyring, e.g. its file name.
+var PassphrasePrompt func(what string) (string, error)
+
+var ErrWrongPassphrase = errors.New("wrong passphrase")
+
+func IsEncryptedKeyring(data string) bool {
+	return strings.HasPrefix(strings.TrimSpace(data), encryptedKeyringPrefix)
+}
+
+// EncryptedData returns the keyring in a passphrase-protected format,
+// using a scrypt-derived key and secretbox.
+func (keyring Keyring) EncryptedData(passphrase string) (string, error) {
+	if passphrase == "" {
+		return "", fmt.Errorf("empty passphrase")
+	}
+	var salt [saltSize]byte
+	var nonce [NonceSize]byte
+	if _, err := io.ReadFull(rand.Reader, salt[:]); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	key, err := passphraseKey(passphrase, salt[:], scryptN, scryptR, scryptP)
+	if err != nil {
+		return "", err
+	}
+	ciphertext := secretbox.Seal(nil, []byte(keyring.DataV2()), &nonce, key)
+
+	return fmt.Sprintf("%sscrypt:%d:%d:%d:%s:%s:%s\n", encryptedKeyringPrefix, scryptN, scryptR, scryptP, base64.StdEncoding.EncodeToString(salt[:]), base64.StdEncoding.EncodeToString(nonce[:]), base64.StdEncoding.EncodeToString(ciphertext)), nil
+}
+
+// DecryptKeyringString decrypts data produced by Keyring.EncryptedData,
+// returning the plain keyring data.
+func DecryptKeyringString(data, passphrase string) (string, error) {
+	str, ok := strings.CutPrefix(strings.TrimSpace(data), encryptedKeyringPrefix)
+	if !ok {
+		return "", fmt.Errorf("not an encrypted keyring")
+	}
+	comps := strings.Split(str, ":")
+	if len(comps) != 7 || comps[0] != "scrypt" {
+		return "", fmt.Errorf(`invalid encrypted keyring, expected "%sscrypt:<N>:<r>:<p>:<salt>:<nonce>:<ciphertext>"`, encryptedKeyringPrefix)
+	}
+	var params [3]int
+	for i := range params {
+		v, err := strconv.Atoi(comps[1+i])
+		if err != nil || v <= 0 {
+			return "", fmt.Errorf("invalid scrypt parameter %q in encrypted keyring", comps[1+i])
+		}
+		params[i] = v
+	}
+	n, r, p := params[0], params[1], params[2]
+	if n < 2 || n&(n-1) != 0 || n > maxScryptN || r > maxScryptR || p > maxScryptP || n*r > maxScryptNR {
+		return "", fmt.Errorf("unsupported scrypt parameters N=%d r=%d p=%d in encrypted keyring, N must be a power of 2 up to %d, r up to %d, p up to %d and N*r up to %d", n, r, p, maxScryptN, maxScryptR, maxScryptP, maxScryptNR)
+	}
+	salt, err := base64.StdEncoding.DecodeString(comps[4])
+	if err != nil {
+		return "", fmt.Errorf("invalid salt in encrypted keyring: %w", err)
+	}
+	if len(salt) != saltSize {
+		return "", fmt.Errorf("invalid salt len in encrypted keyring, got %d, wanted %d", len(salt), saltSize)
+	}
+	nonce, err := base64.StdEncoding.DecodeString(comps[5])
+	if err != nil || len(nonce) != NonceSize {
+		return "", fmt.Errorf("invalid nonce in encrypted keyring")
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(comps[6])
+	if err != nil {
+		return "", fmt.Errorf("invalid ciphertext in encrypted keyring: %w", err)
+	}
+
+	key, err := passphraseKey(passphrase, salt, n, r, p)
+	if err != nil {
+		return "", err
+	}
+	plaintext, ok := secretbox.Open(nil, ciphertext, (*[NonceSize]byte)(nonce), key)
+	if !ok {
+		return "", ErrWrongPassphrase
+	}
+	return string(plaintext), nil
+}
+
+func passphraseKey(passphrase string, salt []byte, n, r, p int) (*[KeySize]byte, error) {
+	derived, err := scrypt.Key([]byte(passphrase), salt, n, r, p, KeySize)
+	if err != nil {
+		return nil, fmt.Errorf("scrypt: %w", err)
+	}
+	return (*[KeySize]byte)(derived), nil
+}
+
+// Passphrase returns the passphrase from PLAINSECRETS_PASSPHRASE or
+// PassphrasePrompt.
+func Passphrase(what string) (string, error) {
+	if v := os.Getenv(PassphraseEnvVar); v != "" {
+		return v, nil
+	}
+	if PassphrasePrompt == nil {
+		return "", fmt.Errorf("%s is encrypted, set %s", what, PassphraseEnvVar)
+	}
+	return PassphrasePrompt(what)
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package plainsecrets
+
+import "os"
+
+// CheckKeyringPermissions only checks that the keyring file exists on
+// platforms without Unix permissions.
+func CheckKeyringPermissions(path string) error {
+	_, err := os.Stat(path)
+	return err
+}
+
+func checkKeyringFileInfo(path string, fi os.FileInfo) error {
+	return nil
+}
@@ -0,0 +1,29 @@
+//go:build unix
+
+package plainsecrets
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// CheckKeyringPermissions returns an error if the keyring file can be read
+// by the group or others, or is owned by another user.
+func CheckKeyringPermissions(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return checkKeyringFileInfo(path, fi)
+}
+
+func checkKeyringFileInfo(path string, fi os.FileInfo) error {
+	if perm := fi.Mode().Perm(); perm&0o077 != 0 {
+		return fmt.Errorf("permissions %04o for %s are too open, the keyring must not be accessible by others (run chmod 600 %s)", perm, path, path)
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok && int(st.Uid) != os.Getuid() {
+		return fmt.Errorf("bad owner for %s, the keyring must be owned by the current user (uid %d, not %d)", path, os.Getuid(), st.Uid)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package plainsecrets
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// KeyProvider looks up keys by name. FindKey returns nil, nil if the key
+// is not available from this provider.
+type KeyProvider interface {
+	FindKey(name string) (*Key, error)
+}
+
+func (keyring Keyring) FindKey(name string) (*Key, error) {
+	return keyring.ByName(name), nil
+}
+
+func lookupKey(keys KeyProvider, name string) (*Key, error) {
+	if keys == nil {
+		return nil, fmt.Errorf("missing key %s", name)
+	}
+	key, err := keys.FindKey(name)
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %w", name, err)
+	}
+	if key == nil {
+		return nil, fmt.Errorf("missing key %s", name)
+	}
+	return key, nil
+}
+
+// KeyChain tries several providers in order.
+type KeyChain []KeyProvider
+
+func (chain KeyChain) FindKey(name string) (*Key, error) {
+	for _, keys := range chain {
+		key, err := keys.FindKey(name)
+		if key != nil || err != nil {
+			return key, err
+		}
+	}
+	return nil, nil
+}
+
+const DefaultKeyEnvPrefix = "PLAINSECRETS_KEY_"
+
+// EnvKeyProvider reads base64-encoded keys from environment variables named
+// Prefix (DefaultKeyEnvPrefix if empty) followed by the key name, with
+// characters other than letters, digits and underscores replaced by
+// underscores, e.g. PLAINSECRETS_KEY_myapp_prod.
+type EnvKeyProvider struct {
+	Prefix string
+}
+
+func (p EnvKeyProvider) FindKey(name string) (*Key, error) {
+	v, ok := os.LookupEnv(p.EnvVarName(name))
+	if !ok {
+		return nil, nil
+	}
+	return parseKeyData(name, strings.TrimSpace(v))
+}
+
+func (p EnvKeyProvider) EnvVarName(keyName string) string {
+	prefix := p.Prefix
+	if prefix == "" {
+		prefix = DefaultKeyEnvPrefix
+	}
+	return prefix + strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, keyName)
+}
+
+// DirKeyProvider reads keys from a directory with one file per key, named
+// after the key and containing either base64-encoded or raw key data.
+type DirKeyProvider struct {
+	Dir string
+}
+
+func (p DirKeyProvider) FindKey(name string) (*Key, error) {
+	if !IsValidKeyName(name) || strings.HasPrefix(name, ".") {
+		return nil, nil
+	}
+	raw, err := os.ReadFile(filepath.Join(p.Dir, name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if len(raw) == KeySize {
+		key := &Key{Name: name}
+		copy(key.Data[:], raw)
+		return key, nil
+	}
+	return parseKeyData(name, string(bytes.TrimSpace(raw)))
+}
+
+// ReaderKeyProvider reads a keyring from a reader, like stdin or a file
+// descriptor inherited from the parent process, the first time a key is
+// requested.
+type ReaderKeyProvider struct {
+	r       io.Reader
+	once    sync.Once
+	keyring Keyring
+	err     error
+}
+
+func NewReaderKeyProvider(r io.Reader) *ReaderKeyProvider {
+	return &ReaderKeyProvider{r: r}
+}
+
+func (p *ReaderKeyProvider) FindKey(name string) (*Key, error) {
+	p.once.Do(func() {
+		raw, err := io.ReadAll(p.r)
+		if err != nil {
+			p.err = err
+			return
+		}
+		p.keyring, p.err = ParseKeyringString(string(raw))
+	})
+	if p.err != nil {
+		return nil, p.err
+	}
+	return p.keyring.ByName(name), nil
+}
+
+func parseKeyData(name, v string) (*Key, error) {
+	keyData, err := base64.StdEncoding.DecodeString(v)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid base64-encoded key: %w", name, err)
+	}
+	if len(keyData) != KeySize {
+		return nil, fmt.Errorf("%s: invalid key size %d, wanted %d", name, len(keyData), KeySize)
+	}
+	key := &Key{Name: name}
+	copy(key.Data[:], keyData)
+	return key, nil
+}
@@ -0,0 +1,44 @@
+package plainsecrets
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestKeyProviders(t *testing.T) {
+	keyring := must(ParseKeyringString(sampleKeyring))
+	prod, dev := keyring.ByName("myapp-prod"), keyring.ByName("myapp-dev")
+
+	t.Setenv("PLAINSECRETS_KEY_myapp_prod", strings.TrimPrefix(strings.Split(sampleKeyring, "\n")[0], "myapp-prod="))
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "myapp-dev"), dev.Data[:], 0600)
+
+	reader := NewReaderKeyProvider(strings.NewReader(sampleKeyring))
+	chain := KeyChain{EnvKeyProvider{}, DirKeyProvider{Dir: dir}, reader}
+
+	if k := must(chain.FindKey("myapp-prod")); k == nil || k.Data != prod.Data {
+		t.Errorf("** myapp-prod via env = %v", k)
+	}
+	if k := must(chain.FindKey("myapp-dev")); k == nil || k.Data != dev.Data {
+		t.Errorf("** myapp-dev via dir = %v", k)
+	}
+	if reader.keyring != nil {
+		t.Errorf("** reader consulted too early")
+	}
+	if k := must(chain.FindKey("other")); k != nil {
+		t.Errorf("** other = %v", k)
+	}
+	if reader.keyring == nil {
+		t.Errorf("** reader not consulted")
+	}
+
+	vals := must(ParseString("@all = foo\nTEST=secret:myapp-prod:XWDflt8oKe6q1/F7PRpSl79UpaGy2mIm:KQ6NmyIgRTR4hxgwzsq5zpYPryhN"))
+	if a, e := tostr3(vals.Value("TEST", "foo", EnvKeyProvider{})), "hello"; a != e {
+		t.Errorf("** TEST = %q, wanted %q", a, e)
+	}
+	if a, e := tostr3(vals.Value("TEST", "foo", DirKeyProvider{Dir: dir})), "ERR: TEST: missing key myapp-prod"; a != e {
+		t.Errorf("** TEST = %q, wanted %q", a, e)
+	}
+}
@@ -0,0 +1,181 @@
+package plainsecrets
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MinRedactLen is the length of the shortest value a Redactor scrubs;
+// shorter values would match too much unrelated text.
+const MinRedactLen = 4
+
+// Redactor scrubs known secret values from text, e.g. log output. It is safe
+// for concurrent use.
+type Redactor struct {
+	mu       sync.RWMutex
+	secrets  map[string]bool
+	replacer *strings.Replacer
+}
+
+func NewRedactor(secrets ...string) *Redactor {
+	r := &Redactor{secrets: make(map[string]bool)}
+	r.Add(secrets...)
+	return r
+}
+
+// Redactor returns a Redactor for the encrypted values of the env, including
+// the previous generations. Plain values are not considered secret. Like
+// EnvValues, returns the last error, if any, along with a Redactor for the
+// values that could be decrypted.
+func (vals *Values) Redactor(env string, keyring KeyProvider) (*Redactor, error) {
+	r := NewRedactor()
+	var lastErr error
+	for _, name := range vals.Names() {
+		entries := vals.entries[name]
+		var maxGeneration int
+		for _, e := range entries {
+			if e.Generation > maxGeneration {
+				maxGeneration = e.Generation
+			}
+		}
+		for gen := 0; gen <= maxGeneration; gen++ {
+			e, err := vals.pickVariant(name, env, entries, gen)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			if e == nil || (e.Encoding != Encrypted && e.Encoding != Envelope && e.Encoding != EncryptedFile) {
+				continue
+			}
+			val, err := vals.entryValue(name, env, e, keyring)
+			if err != nil {
+				lastErr = fmt.Errorf("%s: %w", name, err)
+				continue
+			}
+			r.Add(val)
+		}
+	}
+	return r, lastErr
+}
+
+// Add adds values to scrub. Values shorter than MinRedactLen are ignored.
+func (r *Redactor) Add(secrets ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range secrets {
+		if len(s) >= MinRedactLen {
+			r.secrets[s] = true
+		}
+	}
+
+	// longest first, so that a secret containing another one is scrubbed whole
+	sorted := make([]string, 0, len(r.secrets))
+	for s := range r.secrets {
+		sorted = append(sorted, s)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if len(sorted[i]) != len(sorted[j]) {
+			return len(sorted[i]) > len(sorted[j])
+		}
+		return sorted[i] < sorted[j]
+	})
+	var oldnew []string
+	for _, s := range sorted {
+		oldnew = append(oldnew, s, Redacted)
+	}
+	r.replacer = strings.NewReplacer(oldnew...)
+}
+
+// Redact replaces all known secret values in the string with Redacted.
+func (r *Redactor) Redact(s string) string {
+	r.mu.RLock()
+	replacer := r.replacer
+	r.mu.RUnlock()
+	if replacer == nil {
+		return s
+	}
+	return replacer.Replace(s)
+}
+
+// Writer returns a writer that scrubs secrets from the data written to w.
+// Each Write is scrubbed separately, which suits log.Logger and slog
+// handlers that write one entry at a time.
+func (r *Redactor) Writer(w io.Writer) io.Writer {
+	return &redactingWriter{r, w}
+}
+
+type redactingWriter struct {
+	r *Redactor
+	w io.Writer
+}
+
+func (rw *redactingWriter) Write(p []byte) (int, error) {
+	_, err := io.WriteString(rw.w, rw.r.Redact(string(p)))
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Handler returns a slog.Handler that scrubs secrets from the message and
+// attribute values before passing records on to h.
+func (r *Redactor) Handler(h slog.Handler) slog.Handler {
+	return &redactingHandler{r, h}
+}
+
+type redactingHandler struct {
+	r *Redactor
+	h slog.Handler
+}
+
+func (rh *redactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return rh.h.Enabled(ctx, level)
+}
+
+func (rh *redactingHandler) Handle(ctx context.Context, rec slog.Record) error {
+	out := slog.NewRecord(rec.Time, rec.Level, rh.r.Redact(rec.Message), rec.PC)
+	rec.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(rh.r.redactAttr(a))
+		return true
+	})
+	return rh.h.Handle(ctx, out)
+}
+
+func (rh *redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = rh.r.redactAttr(a)
+	}
+	return &redactingHandler{rh.r, rh.h.WithAttrs(redacted)}
+}
+
+func (rh *redactingHandler) WithGroup(name string) slog.Handler {
+	return &redactingHandler{rh.r, rh.h.WithGroup(name)}
+}
+
+func (r *Redactor) redactAttr(a slog.Attr) slog.Attr {
+	a.Value = a.Value.Resolve()
+	switch a.Value.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(r.Redact(a.Value.String()))
+	case slog.KindGroup:
+		group := a.Value.Group()
+		redacted := make([]slog.Attr, len(group))
+		for i, ga := range group {
+			redacted[i] = r.redactAttr(ga)
+		}
+		a.Value = slog.GroupValue(redacted...)
+	case slog.KindAny:
+		// errors and other values are logged via their string form
+		s := fmt.Sprint(a.Value.Any())
+		if redacted := r.Redact(s); redacted != s {
+			a.Value = slog.StringValue(redacted)
+		}
+	}
+	return a
+}
@@ -0,0 +1,47 @@
+package plainsecrets
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestRedactor(t *testing.T) {
+	keyring := must(ParseKeyringString(sampleKeyring))
+	enc := func(s string) string {
+		return must(New().EncryptValue(s, "prod", "myapp-prod", keyring))
+	}
+	vals := must(ParseString("@all = prod\nLOG_LEVEL = info\nTOKEN = " + enc("tok-123456") + "\nTOKEN~1 = " + enc("tok-old") + "\nPIN = " + enc("42")))
+	r := must(vals.Redactor("prod", keyring))
+
+	if a, e := r.Redact("info: tok-123456 tok-old 42"), "info: [REDACTED] [REDACTED] 42"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+
+	var buf bytes.Buffer
+	logger := log.New(r.Writer(&buf), "", 0)
+	logger.Printf("token is %s", "tok-123456")
+	if a, e := buf.String(), "token is [REDACTED]\n"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+
+	buf.Reset()
+	slogger := slog.New(r.Handler(slog.NewTextHandler(&buf, &slog.HandlerOptions{ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+		if a.Key == slog.TimeKey {
+			return slog.Attr{}
+		}
+		return a
+	}})))
+	slogger.With("old", "tok-old").WithGroup("g").Info("got tok-123456", "err", errors.New("bad token tok-123456"), "n", 42)
+	if a, e := buf.String(), `level=INFO msg="got [REDACTED]" old=[REDACTED] g.err="bad token [REDACTED]" g.n=42`+"\n"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+
+	r.Add("extra-secret")
+	if a := r.Redact("an extra-secret"); strings.Contains(a, "extra") {
+		t.Errorf("** got %q", a)
+	}
+}
@@ -0,0 +1,108 @@
+package plainsecrets
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// KeyUsage returns the LHS of every value referencing each key, including
+// DEFAULT_KEY settings. Values relying on DEFAULT_KEY are not listed under
+// the key itself.
+func (vals *Values) KeyUsage() map[string][]string {
+	result := make(map[string][]string)
+	for name, entries := range vals.entries {
+		for _, e := range entries {
+			for _, keyName := range e.referencedKeys(name) {
+				result[keyName] = append(result[keyName], e.RawLHS)
+			}
+		}
+	}
+	for _, lhss := range result {
+		sort.Strings(lhss)
+	}
+	return result
+}
+
+// referencedKeys returns the names of the keys mentioned by the entry.
+func (e *entry) referencedKeys(name string) []string {
+	switch e.Encoding {
+	case Encrypted, EncryptedFile, ToBeEncrypted, ToBeEncryptedFile, Envelope:
+		if e.KeyName != "" {
+			return strings.Split(e.KeyName, ",")
+		}
+	case Plain:
+		if name == DefaultKey && e.PlainValue != "" {
+			return []string{e.PlainValue}
+		}
+	}
+	return nil
+}
+
+// RenameKeyInString replaces references to the key oldName with newName in
+// secret:, secretfile:, enc:, encfile: and envelope: values and in DEFAULT_KEY
+// settings.
+// Returns the number of values changed.
+func RenameKeyInString(data, oldName, newName string) (string, int, error) {
+	pairs, err := parseKVPairs(data)
+	if err != nil {
+		return "", 0, err
+	}
+
+	lines := strings.Split(data, "\n")
+	var edits []lineEdit
+	for _, p := range pairs {
+		if strings.HasPrefix(p.Key, "@") {
+			continue
+		}
+		name := lhsName(p.Key)
+		var e entry
+		if err := parseValue(p.Value, &e); err != nil {
+			return "", 0, fmt.Errorf("%w in %q", err, p.Key+"="+p.Value)
+		}
+		if !contains(e.referencedKeys(name), oldName) {
+			continue
+		}
+
+		line := lines[p.Line-1]
+		prefix := lhsPrefix(line)
+		rhs := line[len(prefix):]
+		switch e.Encoding {
+		case Plain:
+			rhs = strings.Replace(rhs, oldName, newName, 1)
+		case Envelope:
+			comps := strings.Split(rhs, ":")
+			comps[len(comps)-1] = renameInKeyList(comps[len(comps)-1], oldName, newName)
+			rhs = strings.Join(comps, ":")
+		default:
+			comps := strings.SplitN(rhs, ":", 5)
+			i := 1
+			if e.Cipher != SecretBox {
+				i = 3 // secret:v2:<cipher>:<keyname>:...
+			}
+			comps[i] = renameInKeyList(comps[i], oldName, newName)
+			rhs = strings.Join(comps, ":")
+		}
+		edits = append(edits, lineEdit{p.Line, p.Line, []string{prefix + rhs}})
+	}
+
+	return strings.Join(applyLineEdits(lines, edits), "\n"), len(edits), nil
+}
+
+// renameInKeyList renames the key in a list like name1,name2#fp or
+// name1=...,name2/cipher=....
+func renameInKeyList(list, oldName, newName string) string {
+	items := strings.Split(list, ",")
+	for i, item := range items {
+		if rest, ok := strings.CutPrefix(item, oldName); ok && (rest == "" || strings.ContainsRune("#/=", rune(rest[0]))) {
+			items[i] = newName + rest
+		}
+	}
+	return strings.Join(items, ",")
+}
+
+func RenameKeyInFile(path, oldName, newName string) (int, error) {
+	return updateFile(path, func(data string) (string, int, error) {
+		return RenameKeyInString(data, oldName, newName)
+	})
+}
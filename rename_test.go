@@ -0,0 +1,62 @@
+package plainsecrets
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenameKeyInString(t *testing.T) {
+	keyring := must(ParseKeyringString(sampleKeyring))
+	vals := must(ParseString("@all = prod dev"))
+	vals.Fingerprints = true
+	secret := must(vals.EncryptValue("s", "prod", "myapp-prod", keyring))
+
+	input := "@all = prod dev\nDEFAULT_KEY.prod = myapp-prod\nDEFAULT_KEY.dev = myapp-dev\nA.prod = " + secret + "\nA.dev = enc:myapp-dev:x\nB.prod = enc:myapp-prod:<<EOF\nline\nEOF\nB.dev = myapp-prod\n"
+	usage := must(ParseString(input)).KeyUsage()
+	if a, e := strings.Join(usage["myapp-prod"], " "), "A.prod B.prod DEFAULT_KEY.prod"; a != e {
+		t.Errorf("** myapp-prod usage = %q, wanted %q", a, e)
+	}
+
+	output, n, err := RenameKeyInString(input, "myapp-prod", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, e := n, 3; a != e {
+		t.Errorf("** renamed %d, wanted %d:\n%s", a, e, output)
+	}
+	if a, e := output, strings.Replace(strings.Replace(strings.Replace(input, "prod = myapp-prod", "prod = prod", 1), "secret:myapp-prod#", "secret:prod#", 1), "enc:myapp-prod:", "enc:prod:", 1); a != e {
+		t.Errorf("** got:\n%s\nwanted:\n%s", a, e)
+	}
+
+	keyring.Rename("myapp-prod", "prod")
+	if a, e := tostr3(must(ParseString(output)).Value("A", "prod", keyring)), "s"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+}
+
+func TestKeyringImport(t *testing.T) {
+	keyring := must(ParseKeyringString(sampleKeyring))
+	other := Keyring{keyring.ByName("myapp-dev"), NewKey("new")}
+	if n, err := keyring.Import(other); err != nil || n != 1 {
+		t.Errorf("** Import = %d, %v, wanted 1", n, err)
+	}
+	if a, e := keyring.String(), "(myapp-dev, myapp-prod, new)"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+
+	conflict := NewKey("myapp-prod")
+	_, err := keyring.Import(Keyring{NewKey("other"), conflict})
+	if a, e := tostr3("", err), "ERR: key myapp-prod already exists with different data ("+keyring.ByName("myapp-prod").Ref()+" vs "+conflict.Ref()+")"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+	if keyring.ByName("other") != nil {
+		t.Errorf("** partially imported")
+	}
+
+	if !keyring.Remove("new") || keyring.Remove("new") {
+		t.Errorf("** Remove misbehaved")
+	}
+	if a, e := tostr3("", keyring.Rename("myapp-dev", "myapp-prod")), "ERR: key myapp-prod already exists"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+}
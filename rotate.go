@@ -0,0 +1,71 @@
+package plainsecrets
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// RotateValueInString moves the value of lhs (like NAME or NAME.env) into
+// the previous generation slot lhs~1, shifting older generations down and
+// dropping those beyond keep, and sets lhs to the given new RHS.
+func RotateValueInString(data, lhs, rhs string, keep int) (string, error) {
+	if keep < 1 {
+		return "", fmt.Errorf("must keep at least one previous value")
+	}
+	pairs, err := parseKVPairs(data)
+	if err != nil {
+		return "", err
+	}
+
+	lines := strings.Split(data, "\n")
+	var edits []lineEdit
+	var found bool
+	for _, p := range pairs {
+		var gen int
+		if p.Key == lhs {
+			found = true
+		} else if s, ok := strings.CutPrefix(p.Key, lhs+"~"); ok {
+			gen, err = strconv.Atoi(s)
+			if err != nil {
+				continue
+			}
+		} else {
+			continue
+		}
+
+		line := lines[p.Line-1]
+		if gen >= keep {
+			edits = append(edits, lineEdit{p.Line, p.EndLine, nil})
+			continue
+		}
+		renamed := strings.Replace(line, p.Key, lhs+"~"+strconv.Itoa(gen+1), 1)
+		if gen == 0 {
+			edits = append(edits, lineEdit{p.Line, p.Line, []string{lhsPrefix(line) + rhs, renamed}})
+		} else {
+			edits = append(edits, lineEdit{p.Line, p.Line, []string{renamed}})
+		}
+	}
+	if !found {
+		return "", fmt.Errorf("%s not found", lhs)
+	}
+
+	return strings.Join(applyLineEdits(lines, edits), "\n"), nil
+}
+
+func RotateValueInFile(path, lhs, rhs string, keep int) error {
+	s, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	newData, err := RotateValueInString(string(raw), lhs, rhs, keep)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return os.WriteFile(path, []byte(newData), s.Mode())
+}
@@ -0,0 +1,32 @@
+package plainsecrets
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRotateValueInString(t *testing.T) {
+	keyring := must(ParseKeyringString(sampleKeyring))
+
+	input := "@all = prod dev\nW = a\nW.prod = b\nW.prod~1 = c\nW.prod~2 = d\n"
+	output, err := RotateValueInString(input, "W.prod", must(New().EncryptValue("e", "", "myapp-prod", keyring)), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, e := strings.Count(output, "\n"), 5; a != e {
+		t.Errorf("** got %d lines, wanted %d:\n%s", a, e, output)
+	}
+
+	vals := must(ParseString(output))
+	if a, e := strings.Join(must(vals.ValueSet("W", "prod", keyring)), " "), "e b c"; a != e {
+		t.Errorf("** prod ValueSet = %q, wanted %q", a, e)
+	}
+	if a, e := strings.Join(must(vals.ValueSet("W", "dev", keyring)), " "), "a"; a != e {
+		t.Errorf("** dev ValueSet = %q, wanted %q", a, e)
+	}
+
+	_, err = RotateValueInString(input, "W.dev", "x", 1)
+	if a, e := tostr3("", err), "ERR: W.dev not found"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+}
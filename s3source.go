@@ -0,0 +1,216 @@
+package plainsecrets
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// S3Source fetches a secrets file from an S3-compatible object store,
+// signing requests with AWS Signature Version 4. Retries, caching and
+// conditional requests work as described for HTTPSource, whose settings
+// (other than URL, which is set by Fetch) apply. Header is added to requests
+// before they are signed.
+type S3Source struct {
+	HTTPSource
+
+	Bucket string
+	Key    string
+
+	// VersionID pins a specific version of the object.
+	VersionID string
+
+	// Region defaults to AWS_REGION or AWS_DEFAULT_REGION, or us-east-1.
+	// Cloudflare R2 uses auto.
+	Region string
+
+	// Endpoint is the base URL of an S3-compatible service like MinIO or R2,
+	// e.g. https://minio.example.com:9000, which is accessed with path-style
+	// URLs. Defaults to AWS_ENDPOINT_URL_S3 or AWS_ENDPOINT_URL, or AWS S3
+	// with virtual-hosted-style URLs.
+	Endpoint string
+
+	// Credentials default to AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+	// AWS_SESSION_TOKEN.
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	// Clock returns the signing time, defaults to time.Now.
+	Clock func() time.Time
+
+	s3mu sync.Mutex
+}
+
+// ParseS3URL parses s3://bucket/key, optionally followed by
+// ?versionId=<version>.
+func ParseS3URL(s string) (*S3Source, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+	if u.Scheme != "s3" || u.Host == "" || key == "" {
+		return nil, fmt.Errorf("invalid S3 URL %q, expected s3://bucket/key", s)
+	}
+	return &S3Source{Bucket: u.Host, Key: key, VersionID: u.Query().Get("versionId")}, nil
+}
+
+// OpenSource returns an HTTPSource for http:// and https:// URLs, and an
+// S3Source for s3:// URLs.
+func OpenSource(s string) (Source, error) {
+	switch {
+	case strings.HasPrefix(s, "s3://"):
+		return ParseS3URL(s)
+	case strings.HasPrefix(s, "http://"), strings.HasPrefix(s, "https://"):
+		return &HTTPSource{URL: s}, nil
+	default:
+		return nil, fmt.Errorf("unsupported source %q", s)
+	}
+}
+
+func (src *S3Source) Fetch(ctx context.Context) ([]byte, error) {
+	src.s3mu.Lock()
+	defer src.s3mu.Unlock()
+
+	creds := awsCredentials{src.AccessKeyID, src.SecretAccessKey, src.SessionToken}
+	if creds.accessKeyID == "" {
+		creds = awsCredentials{os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"), os.Getenv("AWS_SESSION_TOKEN")}
+	}
+	if creds.accessKeyID == "" || creds.secretAccessKey == "" {
+		return nil, fmt.Errorf("s3://%s/%s: missing AWS credentials, set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY", src.Bucket, src.Key)
+	}
+	region := firstNonEmpty(src.Region, os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1")
+
+	src.URL = src.objectURL(region)
+	src.signRequest = func(req *http.Request) error {
+		now := time.Now
+		if src.Clock != nil {
+			now = src.Clock
+		}
+		signV4(req, creds, region, "s3", now())
+		return nil
+	}
+	return src.HTTPSource.Fetch(ctx)
+}
+
+func (src *S3Source) objectURL(region string) string {
+	var u string
+	if endpoint := firstNonEmpty(src.Endpoint, os.Getenv("AWS_ENDPOINT_URL_S3"), os.Getenv("AWS_ENDPOINT_URL")); endpoint != "" {
+		u = strings.TrimSuffix(endpoint, "/") + "/" + s3Escape(src.Bucket) + "/" + s3Escape(src.Key)
+	} else {
+		u = "https://" + src.Bucket + ".s3." + region + ".amazonaws.com/" + s3Escape(src.Key)
+	}
+	if src.VersionID != "" {
+		u += "?versionId=" + s3Escape(src.VersionID)
+	}
+	return u
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+type awsCredentials struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+}
+
+// emptyPayloadHash is the SHA-256 of an empty body.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// signV4 signs a bodiless request with AWS Signature Version 4, covering the
+// host and all headers set on the request.
+func signV4(req *http.Request, creds awsCredentials, region, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+	if creds.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3Escape(req.URL.Path),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		emptyPayloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + creds.secretAccessKey)
+	for _, s := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, s)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+creds.accessKeyID+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func canonicalQuery(q url.Values) string {
+	var params []string
+	for k, vs := range q {
+		for _, v := range vs {
+			params = append(params, s3Escape(k)+"="+s3Escape(v))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// s3Escape percent-encodes everything but unreserved characters and slashes,
+// as SigV4 requires for S3.
+func s3Escape(s string) string {
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~' || c == '/' {
+			buf.WriteByte(c)
+		} else {
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,87 @@
+package plainsecrets
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// Redacted is what a Secret prints as.
+const Redacted = "[REDACTED]"
+
+// Secret holds a value that does not reveal itself when printed, logged or
+// marshaled to JSON, so that a stray log.Printf("%v", cfg) does not leak it.
+// Call Reveal to get the actual value.
+type Secret struct {
+	value string
+}
+
+func NewSecret(value string) Secret {
+	return Secret{value}
+}
+
+// Reveal returns the actual value.
+func (s Secret) Reveal() string {
+	return s.value
+}
+
+// IsEmpty says whether the value is empty, without revealing it.
+func (s Secret) IsEmpty() bool {
+	return s.value == ""
+}
+
+func (s Secret) String() string {
+	return Redacted
+}
+
+func (s Secret) GoString() string {
+	return "plainsecrets.Secret(" + Redacted + ")"
+}
+
+// Format prints Redacted for any verb, including %x and %q.
+func (s Secret) Format(f fmt.State, verb rune) {
+	if verb == 'v' && f.Flag('#') {
+		io.WriteString(f, s.GoString())
+	} else {
+		io.WriteString(f, Redacted)
+	}
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + Redacted + `"`), nil
+}
+
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(Redacted), nil
+}
+
+func (s Secret) LogValue() slog.Value {
+	return slog.StringValue(Redacted)
+}
+
+// Secret is like Value, but returns a Secret.
+func (vals *Values) Secret(name string, env string, keyring KeyProvider) (Secret, error) {
+	val, err := vals.Value(name, env, keyring)
+	return Secret{val}, err
+}
+
+// EnvSecrets returns all values of the env as Secrets, decrypting them
+// upfront like EnvView.All.
+func (vals *Values) EnvSecrets(env string, keyring KeyProvider) (map[string]Secret, error) {
+	values, err := vals.allEnvValues(env, keyring)
+	result := make(map[string]Secret, len(values))
+	for name, val := range values {
+		result[name] = Secret{val}
+	}
+	return result, err
+}
+
+// LoadFileSecrets is like LoadFileValues, but returns Secrets.
+func LoadFileSecrets(path, env string, keyring KeyProvider, autoEncrypt bool) (map[string]Secret, error) {
+	values, err := LoadFileValues(path, env, keyring, autoEncrypt)
+	result := make(map[string]Secret, len(values))
+	for name, val := range values {
+		result[name] = Secret{val}
+	}
+	return result, err
+}
@@ -0,0 +1,35 @@
+package plainsecrets
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSecret(t *testing.T) {
+	s := NewSecret("hunter2")
+	cfg := struct {
+		Name     string
+		Password Secret
+	}{"db", s}
+
+	for _, f := range []string{"%v", "%+v", "%s", "%q", "%x", "%#v"} {
+		if a := fmt.Sprintf(f, cfg); strings.Contains(a, "hunter2") || strings.Contains(a, fmt.Sprintf("%x", "hunter2")) || !strings.Contains(a, Redacted) {
+			t.Errorf("** %s: got %s", f, a)
+		}
+	}
+	if a, e := string(must(json.Marshal(cfg))), `{"Name":"db","Password":"[REDACTED]"}`; a != e {
+		t.Errorf("** got %s, wanted %s", a, e)
+	}
+	var buf bytes.Buffer
+	slog.New(slog.NewTextHandler(&buf, nil)).Info("connecting", "password", s)
+	if a := buf.String(); strings.Contains(a, "hunter2") || !strings.Contains(a, "password="+Redacted) {
+		t.Errorf("** got %s", a)
+	}
+	if a, e := s.Reveal(), "hunter2"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+}
@@ -0,0 +1,194 @@
+package plainsecrets
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A key share is a single line that describes itself:
+//
+//	plainsecrets-share:myapp-prod#a1b2:symmetric:3of5:2:<base64>
+//
+// which is share number 2 of 5 of the key myapp-prod, any 3 of which
+// reconstruct it. Shares use Shamir's secret sharing over GF(256), with each
+// byte of the key split independently.
+const sharePrefix = "plainsecrets-share:"
+
+// SplitKey splits the key into n shares, any k of which can reconstruct it
+// via CombineKeyShares.
+func SplitKey(key *Key, n, k int) ([]string, error) {
+	if k < 2 || n < k || n > 255 {
+		return nil, fmt.Errorf("invalid %d of %d split, need 2 <= k <= n <= 255", k, n)
+	}
+
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, KeySize)
+	}
+	coeffs := make([]byte, k)
+	data, err := key.secret()
+	if err != nil {
+		return nil, err
+	}
+	for b, secret := range data {
+		coeffs[0] = secret
+		if _, err := rand.Read(coeffs[1:]); err != nil {
+			return nil, err
+		}
+		for i := range shares {
+			shares[i][b] = gfEval(coeffs, byte(i+1))
+		}
+	}
+
+	result := make([]string, n)
+	for i, data := range shares {
+		result[i] = fmt.Sprintf("%s%s:%s:%dof%d:%d:%s", sharePrefix, key.Ref(), key.Type, k, n, i+1, base64.StdEncoding.EncodeToString(data))
+	}
+	return result, nil
+}
+
+func IsKeyShare(s string) bool {
+	return strings.HasPrefix(strings.TrimSpace(s), sharePrefix)
+}
+
+type keyShare struct {
+	Name        string
+	Fingerprint string
+	Type        KeyType
+	K, N        int
+	X           byte
+	Data        []byte
+}
+
+func parseKeyShare(s string) (*keyShare, error) {
+	str, ok := strings.CutPrefix(strings.TrimSpace(s), sharePrefix)
+	if !ok {
+		return nil, fmt.Errorf("not a key share")
+	}
+	comps := strings.Split(str, ":")
+	if len(comps) != 5 {
+		return nil, fmt.Errorf(`invalid key share, expected "plainsecrets-share:<keyname>#<fingerprint>:<type>:<k>of<n>:<index>:<data>"`)
+	}
+	sh := &keyShare{}
+	var err error
+	if sh.Name, sh.Fingerprint, ok = parseKeyRef(comps[0]); !ok || sh.Fingerprint == "" {
+		return nil, fmt.Errorf("invalid key reference %q in key share", comps[0])
+	}
+	if sh.Type, err = ParseKeyType(comps[1]); err != nil {
+		return nil, err
+	}
+	kStr, nStr, _ := strings.Cut(comps[2], "of")
+	sh.K, err = strconv.Atoi(kStr)
+	if err == nil {
+		sh.N, err = strconv.Atoi(nStr)
+	}
+	if err != nil || sh.K < 2 || sh.N < sh.K {
+		return nil, fmt.Errorf("invalid threshold %q in key share", comps[2])
+	}
+	x, err := strconv.Atoi(comps[3])
+	if err != nil || x < 1 || x > sh.N {
+		return nil, fmt.Errorf("invalid index %q in key share", comps[3])
+	}
+	sh.X = byte(x)
+	sh.Data, err = base64.StdEncoding.DecodeString(comps[4])
+	if err != nil || len(sh.Data) != KeySize {
+		return nil, fmt.Errorf("invalid data in key share %d", x)
+	}
+	return sh, nil
+}
+
+// CombineKeyShares reconstructs a key from the shares produced by SplitKey.
+// Extra shares beyond the threshold are ignored.
+func CombineKeyShares(shares []string) (*Key, error) {
+	var parsed []*keyShare
+	for _, s := range shares {
+		sh, err := parseKeyShare(s)
+		if err != nil {
+			return nil, err
+		}
+		if len(parsed) > 0 {
+			first := parsed[0]
+			if sh.Name != first.Name || sh.Fingerprint != first.Fingerprint || sh.Type != first.Type || sh.K != first.K || sh.N != first.N {
+				return nil, fmt.Errorf("shares do not belong together: %s#%s %dof%d vs %s#%s %dof%d", first.Name, first.Fingerprint, first.K, first.N, sh.Name, sh.Fingerprint, sh.K, sh.N)
+			}
+		}
+		var dup bool
+		for _, other := range parsed {
+			dup = dup || other.X == sh.X
+		}
+		if !dup {
+			parsed = append(parsed, sh)
+		}
+	}
+	if len(parsed) == 0 {
+		return nil, fmt.Errorf("no key shares")
+	}
+	first := parsed[0]
+	if len(parsed) < first.K {
+		return nil, fmt.Errorf("got %d distinct shares of %s, need %d", len(parsed), first.Name, first.K)
+	}
+	parsed = parsed[:first.K]
+
+	key := &Key{Name: first.Name, Type: first.Type}
+	for b := range key.Data {
+		// Lagrange interpolation at x = 0
+		var secret byte
+		for i, si := range parsed {
+			basis := byte(1)
+			for j, sj := range parsed {
+				if i != j {
+					basis = gfMul(basis, gfDiv(sj.X, sj.X^si.X))
+				}
+			}
+			secret ^= gfMul(si.Data[b], basis)
+		}
+		key.Data[b] = secret
+	}
+	if fp := key.Fingerprint(); fp != first.Fingerprint {
+		return nil, fmt.Errorf("combined key has fingerprint %s, wanted %s, some shares are corrupted", fp, first.Fingerprint)
+	}
+	return key, nil
+}
+
+// GF(256) arithmetic with the AES polynomial x^8 + x^4 + x^3 + x + 1.
+var gfExp, gfLog = func() (exp [510]byte, log [256]byte) {
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		exp[i], exp[i+255] = x, x
+		log[x] = byte(i)
+		// multiply by the generator 3
+		hi := x & 0x80
+		x2 := x << 1
+		if hi != 0 {
+			x2 ^= 0x1b
+		}
+		x ^= x2
+	}
+	return
+}()
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
+
+// gfEval evaluates the polynomial with the given coefficients at x.
+func gfEval(coeffs []byte, x byte) byte {
+	var result byte
+	for i := len(coeffs) - 1; i >= 0; i-- {
+		result = gfMul(result, x) ^ coeffs[i]
+	}
+	return result
+}
@@ -0,0 +1,45 @@
+package plainsecrets
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitKey(t *testing.T) {
+	key := must(ParseKeyringString(sampleKeyring)).ByName("myapp-prod")
+	shares := must(SplitKey(key, 5, 3))
+	if p := sharePrefix + key.Ref() + ":symmetric:3of5:2:"; !strings.HasPrefix(shares[1], p) {
+		t.Errorf("** got %q, wanted %q prefix", shares[1], p)
+	}
+
+	for _, subset := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}, {0, 1, 2, 3, 4}} {
+		var picked []string
+		for _, i := range subset {
+			picked = append(picked, shares[i])
+		}
+		combined, err := CombineKeyShares(picked)
+		if err != nil {
+			t.Errorf("** %v: %v", subset, err)
+		} else if combined.Name != key.Name || combined.Data != key.Data {
+			t.Errorf("** %v: combined %s, wanted %s", subset, combined.Ref(), key.Ref())
+		}
+	}
+
+	if a, e := tostr3("", err2(CombineKeyShares([]string{shares[0], shares[1], shares[1]}))), "ERR: got 2 distinct shares of myapp-prod, need 3"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+
+	other := must(SplitKey(NewKey("myapp-prod"), 5, 3))
+	if _, err := CombineKeyShares([]string{shares[0], shares[1], other[2]}); err == nil || !strings.Contains(err.Error(), "do not belong together") {
+		t.Errorf("** got %v, wanted a mismatch error", err)
+	}
+
+	corrupted := shares[2][:len(shares[2])-8] + "AAAAAAA="
+	if _, err := CombineKeyShares([]string{shares[0], shares[1], corrupted}); err == nil || !strings.Contains(err.Error(), "some shares are corrupted") {
+		t.Errorf("** got %v, wanted a corruption error", err)
+	}
+}
+
+func err2[T any](_ T, err error) error {
+	return err
+}
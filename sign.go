@@ -0,0 +1,183 @@
+package plainsecrets
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Signed secrets files start with these lines:
+//
+//	!serial = 12
+//	!signature = myapp-prod#a1b2:<base64 HMAC-SHA256>
+//
+// The signature covers every other line of the file in parsed form, so
+// reformatting and comments do not matter, but changing, adding or removing
+// any value, env group or setting does. It covers the contents of
+// secretfile: files through their digest, but not those of file: and
+// encfile: files, which verification refuses. The serial grows with every
+// signing, so that an older signed file can be told apart from a newer one.
+const (
+	serialLHS    = "!serial"
+	signatureLHS = "!signature"
+)
+
+// parseSignature picks up the !serial and !signature lines and remembers the
+// signed content to be verified later.
+func (vals *Values) parseSignature(values map[string]string) error {
+	for lhs := range values {
+		if strings.HasPrefix(lhs, "!") && lhs != serialLHS && lhs != signatureLHS {
+			return fmt.Errorf("unknown directive %s", lhs)
+		}
+	}
+	vals.serial = 0
+	if s, ok := values[serialLHS]; ok {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("malformed %s %q", serialLHS, s)
+		}
+		vals.serial = n
+	}
+	vals.signature = values[signatureLHS]
+	vals.signedContent = signedContent(values)
+	return nil
+}
+
+// signedContent is the canonical form of the file covered by the signature:
+// all lines except the signature itself, sorted, as lhs=rhs.
+func signedContent(values map[string]string) string {
+	lhss := make([]string, 0, len(values))
+	for lhs := range values {
+		if lhs != signatureLHS {
+			lhss = append(lhss, lhs)
+		}
+	}
+	sort.Strings(lhss)
+
+	var buf strings.Builder
+	buf.WriteString("plainsecrets signed file v1\n")
+	for _, lhs := range lhss {
+		buf.WriteString(lhs)
+		buf.WriteByte('=')
+		buf.WriteString(values[lhs])
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+func computeSignature(key *Key, content string) ([]byte, error) {
+	if key.Type != SymmetricKey {
+		return nil, fmt.Errorf("key %s cannot sign, only symmetric keys can", key.Name)
+	}
+	secret, err := key.secret()
+	if err != nil {
+		return nil, err
+	}
+	var macKey [KeySize]byte
+	_, err = io.ReadFull(hkdf.New(sha256.New, secret[:], nil, []byte("plainsecrets signature")), macKey[:])
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, macKey[:])
+	mac.Write([]byte(content))
+	return mac.Sum(nil), nil
+}
+
+// Serial returns the serial of a signed file, 0 if the file is unsigned.
+func (vals *Values) Serial() int {
+	return vals.serial
+}
+
+// Signed says whether the file has a signature (which may or may not be valid).
+func (vals *Values) Signed() bool {
+	return vals.signature != ""
+}
+
+// Verify checks that the file has been signed with the given key and has not
+// been changed since, and that its serial is not below minSerial, which
+// detects a rollback to an older signed file.
+func (vals *Values) Verify(key *Key, minSerial int) error {
+	if vals.signature == "" {
+		return fmt.Errorf("secrets file is not signed")
+	}
+	ref, sigStr, ok := strings.Cut(vals.signature, ":")
+	name, fingerprint, refOK := parseKeyRef(ref)
+	sig, err := base64.StdEncoding.DecodeString(sigStr)
+	if !ok || !refOK || err != nil {
+		return fmt.Errorf("malformed %s", signatureLHS)
+	}
+	if name != key.Name || (fingerprint != "" && fingerprint != key.Fingerprint()) {
+		return fmt.Errorf("secrets file is signed with %s, expected %s", ref, key.Ref())
+	}
+	expected, err := computeSignature(key, vals.signedContent)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(sig, expected) {
+		return fmt.Errorf("secrets file has been modified after signing (signature mismatch)")
+	}
+	if vals.serial < minSerial {
+		return fmt.Errorf("secrets file has serial %d, expected at least %d (rolled back to an older version?)", vals.serial, minSerial)
+	}
+	return nil
+}
+
+// SignString adds or updates the !serial and !signature lines, incrementing
+// the serial. Returns the new data and the new serial.
+func SignString(data string, key *Key) (string, int, error) {
+	pairs, err := parseKVPairs(data)
+	if err != nil {
+		return "", 0, err
+	}
+	values := make(map[string]string, len(pairs))
+	pairsByKey := make(map[string]*kvPair, len(pairs))
+	for _, p := range pairs {
+		values[p.Key] = p.Value
+		pairsByKey[p.Key] = p
+	}
+
+	vals := New()
+	if err := vals.parseSignature(values); err != nil {
+		return "", 0, err
+	}
+	serial := vals.serial + 1
+	values[serialLHS] = strconv.Itoa(serial)
+	sig, err := computeSignature(key, signedContent(values))
+	if err != nil {
+		return "", 0, err
+	}
+
+	newLines := map[string]string{
+		serialLHS:    serialLHS + " = " + strconv.Itoa(serial),
+		signatureLHS: signatureLHS + " = " + key.Ref() + ":" + base64.StdEncoding.EncodeToString(sig),
+	}
+	lines := strings.Split(data, "\n")
+	var edits []lineEdit
+	var prepend []string
+	for _, lhs := range []string{serialLHS, signatureLHS} {
+		if p := pairsByKey[lhs]; p != nil {
+			edits = append(edits, lineEdit{p.Line, p.EndLine, []string{newLines[lhs]}})
+		} else {
+			prepend = append(prepend, newLines[lhs])
+		}
+	}
+	lines = applyLineEdits(lines, edits)
+	if len(prepend) > 0 {
+		lines = append(prepend, lines...)
+	}
+	return strings.Join(lines, "\n"), serial, nil
+}
+
+// SignFile signs the secrets file in place, see SignString.
+func SignFile(path string, key *Key) (int, error) {
+	return updateFile(path, func(data string) (string, int, error) {
+		return SignString(data, key)
+	})
+}
@@ -0,0 +1,83 @@
+package plainsecrets
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSignString(t *testing.T) {
+	keyring := must(ParseKeyringString(sampleKeyring))
+	key := keyring.ByName("myapp-prod")
+	verify := func(data string, key *Key, minSerial int) error {
+		vals := New()
+		vals.VerifyKey = key
+		vals.MinSerial = minSerial
+		return vals.ParseString(data)
+	}
+
+	input := "# comment\n@all = prod dev\nAPI_URL = https://example.com\n"
+	if err := verify(input, key, 0); err == nil || !strings.Contains(err.Error(), "not signed") {
+		t.Fatalf("** got %v, wanted not signed", err)
+	}
+
+	signed, serial, err := SignString(input, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serial != 1 || !strings.HasPrefix(signed, "!serial = 1\n!signature = myapp-prod#") || !strings.HasSuffix(signed, input) {
+		t.Fatalf("** got serial %d:\n%s", serial, signed)
+	}
+	if err := verify(signed, key, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(strings.Replace(signed, " = https", "=https", 1)+"# another comment\n", key, 0); err != nil {
+		t.Errorf("** formatting: %v", err)
+	}
+	if vals := must(ParseString(signed)); vals.Serial() != 1 || !vals.Signed() || len(vals.Names()) != 1 {
+		t.Errorf("** got serial %d, names %v", vals.Serial(), vals.Names())
+	}
+
+	signed2, serial, err := SignString(signed, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serial != 2 || strings.Count(signed2, "!serial") != 1 || !strings.HasPrefix(signed2, "!serial = 2\n") {
+		t.Fatalf("** got serial %d:\n%s", serial, signed2)
+	}
+
+	// file: contents aren't signed
+	fileSigned, _, err := SignString(input+"CERT = file:cert.pem\n", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		data      string
+		key       string
+		minSerial int
+		err       string
+	}{
+		{strings.Replace(signed, "example.com", "evil.com", 1), "myapp-prod", 0, "modified after signing"},
+		{signed + "DEFAULT_KEY = myapp-dev\n", "myapp-prod", 0, "modified after signing"},
+		{strings.Replace(signed, "!serial = 1", "!serial = 5", 1), "myapp-prod", 0, "modified after signing"},
+		{signed, "myapp-dev", 0, "signed with myapp-prod#"},
+		{signed, "myapp-prod", 2, "serial 1, expected at least 2"},
+		{signed2, "myapp-prod", 2, ""},
+		{fileSigned, "myapp-prod", 0, "file contents are not covered by the signature"},
+	}
+	for _, tt := range tests {
+		err := verify(tt.data, keyring.ByName(tt.key), tt.minSerial)
+		if tt.err == "" && err != nil {
+			t.Errorf("** %s: got %v, wanted no error", tt.key, err)
+		} else if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("** %s: got %v, wanted %q", tt.key, err, tt.err)
+		}
+	}
+
+	if _, _, err := SignString(input, NewBoxKey("box")); err == nil {
+		t.Errorf("** signed with a box key")
+	}
+	if _, err := ParseString("!foo = bar\n@all = prod"); err == nil {
+		t.Errorf("** accepted unknown directive")
+	}
+}
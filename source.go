@@ -0,0 +1,58 @@
+package plainsecrets
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+)
+
+// Source provides the contents of a secrets file kept somewhere other than
+// the local file system, see HTTPSource.
+type Source interface {
+	Fetch(ctx context.Context) ([]byte, error)
+}
+
+// ParseSource fetches the secrets file from the source and parses it.
+// file:, encfile: and secretfile: values are refused unless a base
+// directory has been set with SetDir.
+func (vals *Values) ParseSource(ctx context.Context, src Source) error {
+	data, err := src.Fetch(ctx)
+	if err != nil {
+		return err
+	}
+	vals.remote = true
+	return vals.ParseString(string(data))
+}
+
+// LoadSourceValues is like LoadFileValues for a secrets file fetched from
+// the source (without auto-encryption). It refuses file:, encfile: and
+// secretfile: values, see ParseSource.
+func LoadSourceValues(ctx context.Context, src Source, env string, keyring KeyProvider) (map[string]string, error) {
+	vals := New()
+	if err := vals.ParseSource(ctx, src); err != nil {
+		return nil, err
+	}
+	return vals.allEnvValues(env, keyring)
+}
+
+// writeFileAtomically writes the file via a temporary file and a rename, so
+// that readers never see a partially written file.
+func writeFileAtomically(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
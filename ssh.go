@@ -0,0 +1,184 @@
+package plainsecrets
+
+import (
+	"crypto/ed25519"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultSSHPrivateKeyPath is used to decrypt with SSH keys that don't
+// specify a private key file.
+const DefaultSSHPrivateKeyPath = "~/.ssh/id_ed25519"
+
+// SSHPassphrasePrompt is called to obtain the passphrase of a protected SSH
+// private key file. If nil, such keys cannot be used to decrypt.
+var SSHPassphrasePrompt func(path string) (string, error)
+
+// NewSSHKey makes a key out of an ssh-ed25519 public key in authorized_keys
+// format. Values encrypted with it can be decrypted using the matching
+// private key file, see Key.SSHPrivateKeyPath.
+func NewSSHKey(name, authorizedKey string) (*Key, error) {
+	pub, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(authorizedKey))
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid SSH public key: %w", name, err)
+	}
+	cpk, ok := pub.(ssh.CryptoPublicKey)
+	if !ok || pub.Type() != ssh.KeyAlgoED25519 {
+		return nil, fmt.Errorf("%s: unsupported SSH key type %s, only ssh-ed25519 keys can be used", name, pub.Type())
+	}
+	edPub, ok := cpk.CryptoPublicKey().(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: unsupported SSH key type %s", name, pub.Type())
+	}
+	data, err := ed25519PublicKeyToCurve25519(edPub)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub)))
+	if comment != "" {
+		line += " " + comment
+	}
+	return &Key{Name: name, Type: SSHKey, Data: data, SSHPublicKey: line, Created: time.Now().UTC().Truncate(time.Second), ssh: &sshPrivateKey{}}, nil
+}
+
+type sshPrivateKey struct {
+	mu   sync.Mutex
+	priv *[KeySize]byte
+	dir  string // relative private key paths are relative to this dir
+}
+
+// sshPrivateKey reads the private key file and returns the Curve25519
+// private key. Only success is cached, so that e.g. a mistyped passphrase
+// can be retried.
+func (key *Key) sshPrivateKey() (*[KeySize]byte, error) {
+	if key.ssh == nil {
+		return key.loadSSHPrivateKey()
+	}
+	key.ssh.mu.Lock()
+	defer key.ssh.mu.Unlock()
+	if key.ssh.priv == nil {
+		priv, err := key.loadSSHPrivateKey()
+		if err != nil {
+			return nil, err
+		}
+		key.ssh.priv = priv
+	}
+	return key.ssh.priv, nil
+}
+
+func (key *Key) loadSSHPrivateKey() (*[KeySize]byte, error) {
+	path := key.SSHPrivateKeyPath
+	if path == "" {
+		path = DefaultSSHPrivateKeyPath
+	}
+	path = expandHome(path)
+	if key.ssh != nil && key.ssh.dir != "" && !filepath.IsAbs(path) {
+		path = filepath.Join(key.ssh.dir, path)
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %w", key.Name, err)
+	}
+	parsed, err := ssh.ParseRawPrivateKey(raw)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		if SSHPassphrasePrompt == nil {
+			return nil, fmt.Errorf("key %s: %s is passphrase-protected", key.Name, path)
+		}
+		var passphrase string
+		passphrase, err = SSHPassphrasePrompt(path)
+		if err != nil {
+			return nil, err
+		}
+		parsed, err = ssh.ParseRawPrivateKeyWithPassphrase(raw, []byte(passphrase))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %s: %w", key.Name, path, err)
+	}
+
+	var edPriv ed25519.PrivateKey
+	switch k := parsed.(type) {
+	case ed25519.PrivateKey:
+		edPriv = k
+	case *ed25519.PrivateKey:
+		edPriv = *k
+	default:
+		return nil, fmt.Errorf("key %s: %s is not an ed25519 key", key.Name, path)
+	}
+
+	h := sha512.Sum512(edPriv.Seed())
+	var priv [KeySize]byte
+	copy(priv[:], h[:KeySize])
+	priv[0] &= 248
+	priv[31] &= 127
+	priv[31] |= 64
+
+	pub, err := curve25519.X25519(priv[:], curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	if string(pub) != string(key.Data[:]) {
+		return nil, fmt.Errorf("key %s: %s does not match the public key", key.Name, path)
+	}
+	return &priv, nil
+}
+
+// ed25519PublicKeyToCurve25519 converts an Edwards point to the birationally
+// equivalent Montgomery u-coordinate, u = (1 + y) / (1 - y).
+func ed25519PublicKeyToCurve25519(pub ed25519.PublicKey) ([KeySize]byte, error) {
+	var result [KeySize]byte
+	if len(pub) != ed25519.PublicKeySize {
+		return result, fmt.Errorf("invalid ed25519 public key")
+	}
+	p := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+
+	le := make([]byte, len(pub))
+	copy(le, pub)
+	le[31] &= 0x7f // drop the sign of x
+	y := new(big.Int).SetBytes(reverse(le))
+	if y.Cmp(p) >= 0 {
+		return result, fmt.Errorf("invalid ed25519 public key")
+	}
+
+	one := big.NewInt(1)
+	num := new(big.Int).Add(one, y)
+	den := new(big.Int).Sub(one, y)
+	den.Mod(den, p)
+	if den.Sign() == 0 {
+		return result, fmt.Errorf("invalid ed25519 public key")
+	}
+	u := num.Mul(num, den.ModInverse(den, p))
+	u.Mod(u, p)
+
+	u.FillBytes(result[:])
+	copy(result[:], reverse(result[:]))
+	return result, nil
+}
+
+func reverse(b []byte) []byte {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b
+}
+
+func expandHome(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return path
+}
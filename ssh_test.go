@@ -0,0 +1,64 @@
+package plainsecrets
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSSHKeys(t *testing.T) {
+	alicePub := strings.TrimSpace(string(must(os.ReadFile("testdata/id_ed25519.pub"))))
+	bobPub := strings.TrimSpace(string(must(os.ReadFile("testdata/id_ed25519_bob.pub"))))
+	input := "# plainsecrets keyring v2\n\n[alice-ssh]\ntype = ssh-ed25519\npublic = " + alicePub + "\nprivate = testdata/id_ed25519\n\n[bob-ssh]\ntype = ssh-ed25519\npublic = " + bobPub + "\nprivate = testdata/id_ed25519_bob\n"
+	keyring := must(ParseKeyringString(input))
+	if a, e := keyring.Data(), input; a != e {
+		t.Errorf("** Data() = %q, wanted %q", a, e)
+	}
+
+	vals := must(ParseString("@all = dev"))
+	secrets := "@all = dev\nA = " + must(vals.EncryptValue("hello", "dev", "alice-ssh", keyring)) + "\nB = " + must(vals.EncryptValue("hi", "dev", "bob-ssh", keyring)) + "\n"
+	vals = must(ParseString(secrets))
+	if a, e := tostr3(vals.Value("A", "dev", keyring)), "hello"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+
+	defer func() { SSHPassphrasePrompt = nil }()
+	if a, e := tostr3(vals.Value("B", "dev", keyring)), "ERR: B: key bob-ssh: testdata/id_ed25519_bob is passphrase-protected"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+	var prompted []string
+	SSHPassphrasePrompt = func(path string) (string, error) {
+		prompted = append(prompted, path)
+		return "secret", nil
+	}
+	// the failure above isn't cached, and the success below is
+	for i := 0; i < 2; i++ {
+		if a, e := tostr3(vals.Value("B", "dev", keyring)), "hi"; a != e {
+			t.Errorf("** got %q, wanted %q", a, e)
+		}
+	}
+	if a, e := strings.Join(prompted, " "), "testdata/id_ed25519_bob"; a != e {
+		t.Errorf("** prompted for %q, wanted %q", a, e)
+	}
+
+	// the private key must match the public one
+	mismatched := must(ParseKeyringString(strings.Replace(input, "private = testdata/id_ed25519\n", "private = testdata/id_ed25519_bob\n", 1)))
+	if a, e := tostr3(vals.Value("A", "dev", mismatched)), "ERR: A: key alice-ssh: testdata/id_ed25519_bob does not match the public key"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+
+	// relative to the keyring file when loaded from one
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "id_ed25519"), must(os.ReadFile("testdata/id_ed25519")), 0600)
+	keyringFile := filepath.Join(dir, "keyring.txt")
+	os.WriteFile(keyringFile, []byte(strings.Replace(input, "private = testdata/id_ed25519\n", "private = id_ed25519\n", 1)), 0600)
+	keyring = must(ParseKeyringFile(keyringFile))
+	if a, e := tostr3(vals.Value("A", "dev", keyring)), "hello"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+
+	if _, err := NewSSHKey("x", "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAAgQC6 x"); err == nil {
+		t.Errorf("** accepted a non-ed25519 key")
+	}
+}
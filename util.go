@@ -3,14 +3,44 @@ package plainsecrets
 import (
 	"fmt"
 	"path"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"unicode"
 )
 
+var heredocTerminatorRe = regexp.MustCompile("^[A-Za-z_][A-Za-z0-9_]*$")
+
+type kvPair struct {
+	Key     string
+	Value   string
+	Line    int // 1-based number of the first line
+	EndLine int // 1-based number of the last line, differs from Line for heredocs
+}
+
 func parseMultilineKVString(data string) (map[string]string, error) {
+	pairs, err := parseKVPairs(data)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]string, len(pairs))
+	for _, p := range pairs {
+		result[p.Key] = p.Value
+	}
+	return result, nil
+}
+
+// parseKVPairs parses key=value lines. Heredoc values (KEY = <<EOF, or
+// KEY = enc::<<EOF) are collected verbatim up to the terminator line and
+// returned in quoted form, so that the rest of the code only deals with
+// single-line values.
+func parseKVPairs(data string) ([]*kvPair, error) {
 	lines := strings.Split(data, "\n")
-	result := make(map[string]string, len(lines))
-	for lno, line := range lines {
-		line = strings.TrimSpace(line)
+	result := make([]*kvPair, 0, len(lines))
+	seen := make(map[string]bool, len(lines))
+	for lno := 0; lno < len(lines); lno++ {
+		line := strings.TrimSpace(lines[lno])
 		if line == "" || line[0] == '#' {
 			continue
 		}
@@ -26,15 +56,137 @@ func parseMultilineKVString(data string) (map[string]string, error) {
 			return nil, fmt.Errorf("line %d: missing key", lno+1)
 		}
 
-		if _, dup := result[key]; dup {
+		if seen[key] {
 			return nil, fmt.Errorf("line %d: duplicate value for %s", lno+1, key)
 		}
-		result[key] = value
+		seen[key] = true
+
+		p := &kvPair{Key: key, Value: value, Line: lno + 1, EndLine: lno + 1}
+		if prefix, terminator, ok := cutHeredoc(value); ok {
+			var body []string
+			for {
+				lno++
+				if lno >= len(lines) {
+					return nil, fmt.Errorf("line %d: unterminated heredoc, missing %s", p.Line, terminator)
+				}
+				bodyLine := strings.TrimSuffix(lines[lno], "\r")
+				if strings.TrimSpace(bodyLine) == terminator {
+					break
+				}
+				body = append(body, bodyLine)
+			}
+			p.Value = prefix + strconv.Quote(strings.Join(body, "\n"))
+			p.EndLine = lno + 1
+		}
+		result = append(result, p)
 	}
 
 	return result, nil
 }
 
+func cutHeredoc(value string) (prefix, terminator string, ok bool) {
+	i := strings.LastIndex(value, "<<")
+	if i < 0 {
+		return "", "", false
+	}
+	prefix, terminator = value[:i], value[i+2:]
+	if !heredocTerminatorRe.MatchString(terminator) || !isHeredocPrefix(prefix) {
+		return "", "", false
+	}
+	return prefix, terminator, true
+}
+
+// isHeredocPrefix says whether a heredoc may follow the prefix: nothing, or
+// one of the encodings whose value is unquoted (enc:<keyname>: and TODO:),
+// so that existing literal values like foo:<<EOF keep their meaning.
+func isHeredocPrefix(prefix string) bool {
+	if prefix == "" || prefix == "TODO:" {
+		return true
+	}
+	rest, ok := strings.CutPrefix(prefix, "enc:")
+	if !ok {
+		return false
+	}
+	keyNames, rest, ok := strings.Cut(rest, ":")
+	if !ok || rest != "" {
+		return false
+	}
+	if keyNames != "" {
+		for _, name := range strings.Split(keyNames, ",") {
+			if !IsValidKeyName(name) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// unquote decodes a "double-quoted" string with Go escape sequences or
+// a 'single-quoted' string taken literally. Returns ok == false if str is
+// not quoted.
+func unquote(str string) (s string, ok bool, err error) {
+	if str == "" {
+		return str, false, nil
+	}
+	switch str[0] {
+	case '"':
+		s, err = strconv.Unquote(str)
+		if err != nil {
+			return "", true, fmt.Errorf("malformed quoted string %s", str)
+		}
+		return s, true, nil
+	case '\'':
+		if len(str) < 2 || str[len(str)-1] != '\'' {
+			return "", true, fmt.Errorf("missing closing quote in %s", str)
+		}
+		return str[1 : len(str)-1], true, nil
+	default:
+		return str, false, nil
+	}
+}
+
+// quoteIfNeeded returns str in a form that unquote decodes back to str.
+func quoteIfNeeded(str string) string {
+	if str != strings.TrimSpace(str) || strings.Contains(str, "<<") || (str != "" && (str[0] == '"' || str[0] == '\'')) || strings.IndexFunc(str, isNonPrintable) >= 0 {
+		return strconv.Quote(str)
+	}
+	return str
+}
+
+func isNonPrintable(r rune) bool {
+	return !unicode.IsPrint(r)
+}
+
+type lineEdit struct {
+	Start, End int // 1-based inclusive range of lines to replace
+	Lines      []string
+}
+
+// applyLineEdits replaces line ranges, which must not overlap.
+func applyLineEdits(lines []string, edits []lineEdit) []string {
+	sort.Slice(edits, func(i, j int) bool {
+		return edits[i].Start > edits[j].Start
+	})
+	for _, ed := range edits {
+		tail := append([]string(nil), lines[ed.End:]...)
+		lines = append(append(lines[:ed.Start-1], ed.Lines...), tail...)
+	}
+	return lines
+}
+
+// lhsPrefix returns the part of a key=value line up to the start of the value.
+func lhsPrefix(line string) string {
+	i := strings.IndexByte(line, '=')
+	if i < 0 {
+		return line
+	}
+	i++
+	for i < len(line) && (line[i] == ' ' || line[i] == '\t') {
+		i++
+	}
+	return line[:i]
+}
+
 func matches(pattern, candidate string) bool {
 	matched, _ := path.Match(pattern, candidate)
 	return matched
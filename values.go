@@ -1,17 +1,16 @@
 package plainsecrets
 
 import (
-	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
 	"fmt"
-	"io"
 	"os"
 	"path"
-	"regexp"
+	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
-
-	"golang.org/x/crypto/nacl/secretbox"
+	"time"
 )
 
 const (
@@ -22,11 +21,44 @@ const (
 )
 
 type Values struct {
+	// Clock returns the time used to pick scheduled values, defaults to time.Now.
+	Clock func() time.Time
+
+	// Fingerprints makes newly encrypted values record the fingerprint of the
+	// key used (secret:name#a1b2:...), so that decrypting with a different key
+	// of the same name produces a helpful error.
+	Fingerprints bool
+
+	// OnDecrypt, if set, is called after every attempt to decrypt a value,
+	// successful or not (err is nil on success), e.g. to keep an audit trail
+	// (see AuditLog). keyName is a comma-separated list for envelope values.
+	OnDecrypt func(name, env, keyName string, err error)
+
+	// Padding pads newly encrypted values to a multiple of this many bytes,
+	// overriding the PADDING setting of the secrets file.
+	Padding int
+
+	// VerifyKey makes parsing refuse files that are not signed with this key,
+	// or have been modified after signing. See SignFile. The signature does
+	// not cover the contents of file: and encfile: files, so such values are
+	// refused too; secretfile: values are fine, their digest is signed.
+	VerifyKey *Key
+
+	// MinSerial makes parsing with VerifyKey refuse signed files with a lower
+	// serial, i.e. older versions of the file.
+	MinSerial int
+
+	dir          string
+	remote       bool // fetched from a Source, file paths need SetDir
 	envs         map[string]*envGroup
 	entries      map[string][]*entry
 	resolvedEnvs map[string]*resolvedEnvGroup
 	validEnvs    []string
 	knownEnvs    []string
+
+	serial        int
+	signature     string
+	signedContent string
 }
 
 func New() *Values {
@@ -37,14 +69,19 @@ func New() *Values {
 	}
 }
 
-func LoadFileValues(path, env string, keyring Keyring, autoEncrypt bool) (map[string]string, error) {
+// LoadFileValues parses the secrets file and returns all values of the env,
+// optionally encrypting the values marked for encryption first. It decrypts
+// every value upfront, and its Values have no OnDecrypt hook; to audit the
+// secrets an app actually uses, parse the file and use Values.EnvValues
+// instead.
+func LoadFileValues(path, env string, keyring KeyProvider, autoEncrypt bool) (map[string]string, error) {
 	vals, err := ParseFile(path)
 	if err != nil {
 		return nil, err
 	}
 
 	if autoEncrypt {
-		_, failed, err := vals.EncryptAllInFile(path, keyring)
+		n, failed, err := vals.EncryptAllInFile(path, keyring)
 		if err != nil {
 			return nil, fmt.Errorf("autoencrypt failed: %w", err)
 		}
@@ -60,26 +97,56 @@ func LoadFileValues(path, env string, keyring Keyring, autoEncrypt bool) (map[st
 			}
 			return nil, fmt.Errorf("autoencrypt failed: %s", strings.Join(msgs, ", "))
 		}
+		if n > 0 {
+			// generated values only become known after encryption
+			vals, err = ParseFile(path)
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 
-	return vals.EnvValues(env, keyring)
+	return vals.allEnvValues(env, keyring)
+}
+
+// LoadSignedFileValues is like LoadFileValues, but refuses files that are
+// not signed with verifyKey, have been modified after signing, or have a
+// serial below minSerial. Returns the serial of the file, which the caller
+// can remember as the new minSerial to detect rollbacks.
+func LoadSignedFileValues(path, env string, keyring KeyProvider, verifyKey *Key, minSerial int) (map[string]string, int, error) {
+	vals := New()
+	vals.VerifyKey = verifyKey
+	vals.MinSerial = minSerial
+	err := vals.ParseFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	m, err := vals.allEnvValues(env, keyring)
+	return m, vals.serial, err
 }
 
-func LoadStringValues(data, env string, keyring Keyring) (map[string]string, error) {
+func LoadStringValues(data, env string, keyring KeyProvider) (map[string]string, error) {
 	vals, err := ParseString(data)
 	if err != nil {
 		return nil, err
 	}
-	return vals.EnvValues(env, keyring)
+	return vals.allEnvValues(env, keyring)
 }
 
-func LoadMapValues(data map[string]string, env string, keyring Keyring) (map[string]string, error) {
+func LoadMapValues(data map[string]string, env string, keyring KeyProvider) (map[string]string, error) {
 	vals := New()
 	err := vals.ParseMap(data)
 	if err != nil {
 		return nil, err
 	}
-	return vals.EnvValues(env, keyring)
+	return vals.allEnvValues(env, keyring)
+}
+
+func (vals *Values) now() time.Time {
+	if vals.Clock != nil {
+		return vals.Clock()
+	}
+	return time.Now()
 }
 
 func (vals *Values) String() string {
@@ -181,17 +248,45 @@ func (vals *Values) resolveEnv(env string) (*resolvedEnvGroup, error) {
 }
 
 type entry struct {
-	Env      string
-	Resolved *resolvedEnvGroup
+	Env        string
+	Resolved   *resolvedEnvGroup
+	ActiveFrom time.Time // zero unless scheduled
+	Generation int       // 0 for current value, 1+ for previous ones
 
 	RawLHS string
 	RawRHS string
 
-	Encoding   Encoding
-	KeyName    string
-	Nonce      [NonceSize]byte
-	Ciphertext []byte
-	PlainValue string
+	Encoding       Encoding
+	KeyName        string
+	KeyFingerprint string // empty if not recorded
+	Cipher         Cipher // for symmetric keys
+	Nonce          []byte // nil for sealed boxes
+	Ciphertext     []byte
+	PlainValue     string
+	Path           string // resolved file path for file-based encodings
+	Digest         [sha256.Size]byte
+	Recipients     []recipient // for envelopes
+	Padded         bool        // plaintext is followed by padding
+}
+
+func (e *entry) keyRef() string {
+	if e.KeyFingerprint != "" {
+		return e.KeyName + "#" + e.KeyFingerprint
+	}
+	return e.KeyName
+}
+
+// cipherPrefix returns the v2:<cipher>: part of values that use a cipher
+// other than the default.
+func (e *entry) cipherPrefix() string {
+	return cipherPrefix(e.Cipher)
+}
+
+func cipherPrefix(c Cipher) string {
+	if c == SecretBox {
+		return ""
+	}
+	return "v2:" + c.String() + ":"
 }
 
 func (e *entry) String(name string) string {
@@ -207,50 +302,140 @@ func (e *entry) String(name string) string {
 	case NoValue:
 		buf.WriteString("NONE")
 	case Plain:
-		buf.WriteString(e.PlainValue)
+		if isReservedValue(e.PlainValue) {
+			buf.WriteString(strconv.Quote(e.PlainValue))
+		} else {
+			buf.WriteString(quoteIfNeeded(e.PlainValue))
+		}
 	case ToBeEncrypted:
 		buf.WriteString("enc:")
-		buf.WriteString(e.PlainValue)
+		buf.WriteString(e.KeyName)
+		buf.WriteByte(':')
+		buf.WriteString(quoteIfNeeded(e.PlainValue))
 	case Placeholder:
 		buf.WriteString("TODO")
 		if e.PlainValue != "" {
 			buf.WriteByte(':')
-			buf.WriteString(e.PlainValue)
+			buf.WriteString(quoteIfNeeded(e.PlainValue))
 		}
 	case Encrypted:
 		buf.WriteString("secret:")
-		buf.WriteString(e.KeyName)
+		buf.WriteString(e.cipherPrefix())
+		buf.WriteString(e.keyRef())
 		buf.WriteByte(':')
 		buf.WriteString(base64.StdEncoding.EncodeToString(e.Nonce[:]))
 		buf.WriteByte(':')
 		buf.WriteString(base64.StdEncoding.EncodeToString(e.Ciphertext))
+		if e.Padded {
+			buf.WriteString(paddedSuffix)
+		}
+	case File:
+		buf.WriteString("file:")
+		buf.WriteString(e.PlainValue)
+	case ToBeEncryptedFile:
+		buf.WriteString("encfile:")
+		buf.WriteString(e.KeyName)
+		buf.WriteByte(':')
+		buf.WriteString(e.PlainValue)
+	case ToBeGenerated:
+		buf.WriteString("gen:")
+		buf.WriteString(e.PlainValue)
+	case Envelope:
+		buf.WriteString(e.envelopeRHS())
+	case EncryptedFile:
+		buf.WriteString("secretfile:")
+		buf.WriteString(e.cipherPrefix())
+		buf.WriteString(e.keyRef())
+		buf.WriteByte(':')
+		buf.WriteString(e.PlainValue)
+		buf.WriteByte(':')
+		buf.WriteString(base64.StdEncoding.EncodeToString(e.Digest[:]))
 	}
 	return buf.String()
 }
 
-func (e *entry) Value(keyring Keyring) (string, error) {
+func (e *entry) Value(keyring KeyProvider) (string, error) {
+	b, err := e.ValueBytes(keyring)
+	str := string(b)
+	Wipe(b)
+	return str, err
+}
+
+// ValueBytes returns the value as a fresh byte slice, which the caller may
+// Wipe after use.
+func (e *entry) ValueBytes(keyring KeyProvider) ([]byte, error) {
 	switch e.Encoding {
 	case NoValue:
-		return "", nil
+		return nil, nil
 	case Plain, ToBeEncrypted:
-		return e.PlainValue, nil
+		return []byte(e.PlainValue), nil
 	case Placeholder:
-		return "", fmt.Errorf("forgot to specify")
-	case Encrypted:
-		key := keyring.ByName(e.KeyName)
-		if key == nil {
-			return "", fmt.Errorf("missing key %s", e.KeyName)
+		return nil, fmt.Errorf("forgot to specify")
+	case Encrypted, Envelope:
+		plaintext, _, err := e.unpaddedPlaintext(keyring)
+		return plaintext, err
+	case File, ToBeEncryptedFile:
+		raw, err := os.ReadFile(e.Path)
+		if err != nil {
+			return nil, err
+		}
+		return raw, nil
+	case EncryptedFile:
+		key, err := lookupKey(keyring, e.KeyName)
+		if err != nil {
+			return nil, err
 		}
-		plaintext, ok := secretbox.Open(nil, e.Ciphertext, &e.Nonce, &key.Data)
-		if !ok {
-			return "", fmt.Errorf("decryption failed")
+		raw, err := os.ReadFile(e.Path)
+		if err != nil {
+			return nil, err
+		}
+		if sha256.Sum256(raw) != e.Digest {
+			return nil, fmt.Errorf("digest mismatch for %s", e.Path)
+		}
+		var nonce []byte
+		if key.Type == SymmetricKey {
+			n := e.Cipher.NonceSize()
+			if len(raw) < n {
+				return nil, fmt.Errorf("%s is too short", e.Path)
+			}
+			nonce, raw = raw[:n], raw[n:]
 		}
-		return string(plaintext), nil
+		plaintext, err := openRef(key, e.KeyFingerprint, e.Cipher, nonce, raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", e.Path, err)
+		}
+		return plaintext, nil
+	case ToBeGenerated:
+		return nil, fmt.Errorf("not generated yet")
 	default:
 		panic("unreachable")
 	}
 }
 
+// decrypt returns the plaintext of secret: and envelope: values, including
+// any padding, and the name of the key that decrypted it.
+func (e *entry) decrypt(keyring KeyProvider) ([]byte, string, error) {
+	if e.Encoding == Envelope {
+		return e.openEnvelope(keyring)
+	}
+	key, err := lookupKey(keyring, e.KeyName)
+	if err != nil {
+		return nil, "", err
+	}
+	plaintext, err := openRef(key, e.KeyFingerprint, e.Cipher, e.Nonce, e.Ciphertext)
+	return plaintext, e.KeyName, err
+}
+
+// unpaddedPlaintext is decrypt with the padding stripped.
+func (e *entry) unpaddedPlaintext(keyring KeyProvider) ([]byte, string, error) {
+	raw, keyName, err := e.decrypt(keyring)
+	if err != nil {
+		return nil, "", err
+	}
+	plaintext, err := unpadPlaintext(raw, e.Padded)
+	return plaintext, keyName, err
+}
+
 type Encoding int
 
 const (
@@ -259,6 +444,11 @@ const (
 	Placeholder
 	Encrypted
 	ToBeEncrypted
+	File
+	ToBeEncryptedFile
+	EncryptedFile
+	ToBeGenerated
+	Envelope
 )
 
 func (vals *Values) rebuild() error {
@@ -319,10 +509,10 @@ func (vals *Values) rebuild() error {
 		}
 		for _, env := range vals.validEnvs {
 			sampleEnv := strings.ReplaceAll(env, "*", "xxx")
-			e, err := vals.pickVariant(name, sampleEnv, entries)
+			e, err := vals.pickVariant(name, sampleEnv, entries, 0)
 			if err != nil {
 				return err
-			} else if e == nil {
+			} else if e == nil && !vals.hasScheduledVariant(name, sampleEnv, entries) {
 				return fmt.Errorf("no value for %s.%s", name, env)
 			}
 		}
@@ -340,8 +530,24 @@ func (vals *Values) rebuild() error {
 	return nil
 }
 
-func (vals *Values) pickVariant(name, env string, entries []*entry) (*entry, error) {
+func (vals *Values) pickVariant(name, env string, entries []*entry, generation int) (*entry, error) {
+	return vals.pickVariantAt(name, env, entries, generation, vals.now())
+}
 
+// hasScheduledVariant reports whether a value for the env will become active
+// once all scheduled entries are.
+func (vals *Values) hasScheduledVariant(name, env string, entries []*entry) bool {
+	var last time.Time
+	for _, e := range entries {
+		if e.ActiveFrom.After(last) {
+			last = e.ActiveFrom
+		}
+	}
+	e, _ := vals.pickVariantAt(name, env, entries, 0, last)
+	return e != nil
+}
+
+func (vals *Values) pickVariantAt(name, env string, entries []*entry, generation int, now time.Time) (*entry, error) {
 	envRes, err := vals.resolveEnv(env)
 	if err != nil {
 		return nil, err
@@ -353,6 +559,9 @@ func (vals *Values) pickVariant(name, env string, entries []*entry) (*entry, err
 	var bestScore int
 	var conflict *entry
 	for _, e := range entries {
+		if e.Generation != generation || e.ActiveFrom.After(now) {
+			continue
+		}
 		var score int
 		if envRes.trivial != "" {
 			score = e.Resolved.Match(envRes.trivial)
@@ -369,7 +578,15 @@ func (vals *Values) pickVariant(name, env string, entries []*entry) (*entry, err
 		} else if best != nil && score == bestScore {
 			cmp := e.Resolved.CompareSpecificity(best.Resolved)
 			// log.Printf("(%s) <=> (%s) = %d", res.String(), best.Resolved.String(), cmp)
-			if cmp > 0 {
+			if e.Env == best.Env && !e.ActiveFrom.Equal(best.ActiveFrom) {
+				// scheduled replacement, the newest active one wins
+				if e.ActiveFrom.After(best.ActiveFrom) {
+					best = e
+					if conflict != nil && conflict.Env == e.Env {
+						conflict = nil // the duplicate has been superseded too
+					}
+				}
+			} else if cmp > 0 {
 				best, bestScore, conflict = e, score, nil
 			} else if cmp == 0 {
 				conflict = e
@@ -386,10 +603,20 @@ func (vals *Values) pickVariant(name, env string, entries []*entry) (*entry, err
 	}
 }
 
-func (vals *Values) Value(name string, env string, keyring Keyring) (string, error) {
+func (vals *Values) Value(name string, env string, keyring KeyProvider) (string, error) {
+	b, err := vals.ValueBytes(name, env, keyring)
+	str := string(b)
+	Wipe(b)
+	return str, err
+}
+
+// ValueBytes is like Value, but returns a fresh byte slice instead of a
+// string, so that a decrypted secret can be wiped after use (see Wipe)
+// rather than linger in memory until garbage collected.
+func (vals *Values) ValueBytes(name string, env string, keyring KeyProvider) ([]byte, error) {
 	isNew, err := vals.mentionEnv(env)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	if isNew {
 		vals.rebuild()
@@ -397,51 +624,116 @@ func (vals *Values) Value(name string, env string, keyring Keyring) (string, err
 
 	entries := vals.entries[name]
 	if entries == nil {
-		return "", nil
+		return nil, nil
 	}
 
-	e, err := vals.pickVariant(name, env, entries)
+	e, err := vals.pickVariant(name, env, entries, 0)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	if e == nil {
-		return "", fmt.Errorf("no value for %s.%s", name, env)
+		if vals.hasScheduledVariant(name, env, entries) {
+			return nil, nil // doesn't exist yet
+		}
+		return nil, fmt.Errorf("no value for %s.%s", name, env)
 	}
 
-	val, err := e.Value(keyring)
+	val, err := vals.entryValueBytes(name, env, e, keyring)
 	if err != nil {
-		return "", fmt.Errorf("%s: %w", name, err)
+		return nil, fmt.Errorf("%s: %w", name, err)
 	}
 	return val, nil
 }
 
+// ValueSet returns the current value followed by the previous generations
+// declared as NAME.env~1, NAME.env~2 etc, skipping the generations that
+// have no value for the env.
+func (vals *Values) ValueSet(name string, env string, keyring KeyProvider) ([]string, error) {
+	current, err := vals.Value(name, env, keyring)
+	if err != nil {
+		return nil, err
+	}
+	result := []string{current}
+
+	var maxGeneration int
+	for _, e := range vals.entries[name] {
+		if e.Generation > maxGeneration {
+			maxGeneration = e.Generation
+		}
+	}
+	for gen := 1; gen <= maxGeneration; gen++ {
+		e, err := vals.pickVariant(name, env, vals.entries[name], gen)
+		if err != nil {
+			return nil, err
+		}
+		if e == nil {
+			continue
+		}
+		val, err := vals.entryValue(name, env, e, keyring)
+		if err != nil {
+			return nil, fmt.Errorf("%s~%d: %w", name, gen, err)
+		}
+		if val != "" {
+			result = append(result, val)
+		}
+	}
+	return result, nil
+}
+
 type Variant struct {
-	Name    string
-	Env     string
-	RawLHS  string
-	RawRHS  string
-	KeyName string
-	Value   string
-	Err     error
+	Name       string
+	Env        string
+	RawLHS     string
+	RawRHS     string
+	KeyName    string
+	Value      string // for ToBeEncryptedFile, the path of the file to encrypt; for ToBeGenerated, the spec
+	Err        error
+	Encoding   Encoding
+	ActiveFrom time.Time
+	Generation int
 }
 
 func (v *Variant) Raw() string {
 	return v.RawLHS + "=" + v.RawRHS
 }
 
+func (e *entry) variant(name, value string, err error) *Variant {
+	return &Variant{name, e.Env, e.RawLHS, e.RawRHS, e.KeyName, value, err, e.Encoding, e.ActiveFrom, e.Generation}
+}
+
 func (vals *Values) VariantsToEncrypt() []*Variant {
 	var result []*Variant
 	for name, vars := range vals.entries {
 		for _, e := range vars {
-			if e.Encoding == ToBeEncrypted {
-				result = append(result, &Variant{name, e.Env, e.RawLHS, e.RawRHS, e.KeyName, e.PlainValue, nil})
+			if e.Encoding == ToBeEncrypted || e.Encoding == ToBeEncryptedFile || e.Encoding == ToBeGenerated {
+				result = append(result, e.variant(name, e.PlainValue, nil))
+			}
+		}
+	}
+	return result
+}
+
+// Upcoming returns scheduled variants that are not active yet, soonest first.
+func (vals *Values) Upcoming() []*Variant {
+	now := vals.now()
+	var result []*Variant
+	for name, entries := range vals.entries {
+		for _, e := range entries {
+			if e.ActiveFrom.After(now) {
+				result = append(result, e.variant(name, "", nil))
 			}
 		}
 	}
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].ActiveFrom.Equal(result[j].ActiveFrom) {
+			return result[i].ActiveFrom.Before(result[j].ActiveFrom)
+		}
+		return result[i].RawLHS < result[j].RawLHS
+	})
 	return result
 }
 
-func (vals *Values) ValueVariants(name string, keyring Keyring) []*Variant {
+func (vals *Values) ValueVariants(name string, keyring KeyProvider) []*Variant {
 	entries := vals.entries[name]
 	if entries == nil {
 		return nil
@@ -449,8 +741,8 @@ func (vals *Values) ValueVariants(name string, keyring Keyring) []*Variant {
 
 	result := make([]*Variant, 0, len(entries))
 	for _, e := range entries {
-		val, err := e.Value(keyring)
-		result = append(result, &Variant{name, e.Env, e.RawLHS, e.RawRHS, e.KeyName, val, err})
+		val, err := vals.entryValue(name, e.Env, e, keyring)
+		result = append(result, e.variant(name, val, err))
 	}
 	return result
 }
@@ -464,57 +756,258 @@ func (vals *Values) Names() []string {
 	return names
 }
 
-func (vals *Values) EnvValues(env string, keyring Keyring) (map[string]string, error) {
-	result := make(map[string]string, len(vals.entries))
-	var lastErr error
-	for _, name := range vals.Names() {
-		val, err := vals.Value(name, env, keyring)
-		if err != nil {
-			lastErr = err
-		} else if val != "" {
-			result[name] = val
-		}
+// EnvValues returns a view of the values of the env that decrypts each
+// value on first access, so that with OnDecrypt set, the audit trail lists
+// only the values the app actually uses. Fails if the env is invalid.
+func (vals *Values) EnvValues(env string, keyring KeyProvider) (*EnvView, error) {
+	isNew, err := vals.mentionEnv(env)
+	if err != nil {
+		return nil, err
+	}
+	if isNew {
+		vals.rebuild()
+	}
+	return &EnvView{vals: vals, env: env, keyring: keyring, values: make(map[string]string)}, nil
+}
+
+// allEnvValues decrypts all values of the env, see EnvView.All.
+func (vals *Values) allEnvValues(env string, keyring KeyProvider) (map[string]string, error) {
+	ev, err := vals.EnvValues(env, keyring)
+	if err != nil {
+		return nil, err
+	}
+	return ev.All()
+}
+
+// EncryptValue encrypts the value with the given key, or with the env's
+// DEFAULT_KEY if keyName is empty. A comma-separated list of key names
+// produces an envelope value that any of the keys can decrypt.
+func (vals *Values) EncryptValue(val string, env string, keyName string, keyring KeyProvider) (string, error) {
+	if strings.Contains(keyName, ",") {
+		return vals.EncryptEnvelope(val, env, strings.Split(keyName, ","), keyring)
+	}
+	key, err := vals.encryptionKey(env, keyName, keyring)
+	if err != nil {
+		return "", err
+	}
+
+	nonce, ciphertext, padded, err := vals.sealPadded(key, []byte(val), env)
+	if err != nil {
+		return "", err
+	}
+
+	rhs := fmt.Sprintf("secret:%s%s:%s:%s", cipherPrefix(key.Cipher), vals.keyRef(key), base64.StdEncoding.EncodeToString(nonce), base64.StdEncoding.EncodeToString(ciphertext))
+	if padded {
+		rhs += paddedSuffix
 	}
-	return result, lastErr
+	return rhs, nil
 }
 
-func (vals *Values) EncryptValue(val string, env string, keyName string, keyring Keyring) (string, error) {
+// EncryptFile encrypts the file at the given path (relative to the secrets
+// file) into a companion path+".enc" file, and returns a secretfile: value
+// referencing it.
+func (vals *Values) EncryptFile(path string, env string, keyName string, keyring KeyProvider) (string, error) {
+	key, err := vals.encryptionKey(env, keyName, keyring)
+	if err != nil {
+		return "", err
+	}
+
+	fullPath, err := vals.resolvePath(path)
+	if err != nil {
+		return "", err
+	}
+	s, err := os.Stat(fullPath)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := os.ReadFile(fullPath)
+	if err != nil {
+		return "", err
+	}
+
+	nonce, ciphertext, err := seal(key, plaintext)
+	if err != nil {
+		return "", err
+	}
+	data := append(nonce, ciphertext...)
+
+	err = os.WriteFile(fullPath+".enc", data, s.Mode())
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256(data)
+	return fmt.Sprintf("secretfile:%s%s:%s:%s", cipherPrefix(key.Cipher), vals.keyRef(key), path+".enc", base64.StdEncoding.EncodeToString(digest[:])), nil
+}
+
+func (vals *Values) keyRef(key *Key) string {
+	if vals.Fingerprints {
+		return key.Ref()
+	}
+	return key.Name
+}
+
+func (vals *Values) encryptionKey(env string, keyName string, keyring KeyProvider) (*Key, error) {
 	var keyNameDerived bool
 	if keyName == "" {
 		if env == "" {
-			return "", fmt.Errorf("either env or key name must be specified")
+			return nil, fmt.Errorf("either env or key name must be specified")
 		}
 		var err error
 		keyName, err = vals.Value(DefaultKey, env, nil)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 		if keyName == "" {
-			return "", fmt.Errorf("%s is empty for env %s", DefaultKey, env)
+			return nil, fmt.Errorf("%s is empty for env %s", DefaultKey, env)
 		}
 		keyNameDerived = true
 	}
 
-	key := keyring.ByName(keyName)
+	var key *Key
+	if keyring != nil {
+		var err error
+		key, err = keyring.FindKey(keyName)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", keyName, err)
+		}
+	}
 	if key == nil {
 		if keyNameDerived {
-			return "", fmt.Errorf("no key %s (via %s)", keyName, DefaultKey)
+			return nil, fmt.Errorf("no key %s (via %s)", keyName, DefaultKey)
 		} else {
-			return "", fmt.Errorf("no key %s", keyName)
+			return nil, fmt.Errorf("no key %s", keyName)
 		}
 	}
+	if err := vals.checkKeyScope(key, env); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
 
-	var nonce [NonceSize]byte
-	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
-		return "", fmt.Errorf("failed to generate none: %w", err)
+// checkKeyScope verifies that every env covered by env is within the key's scope.
+func (vals *Values) checkKeyScope(key *Key, env string) error {
+	if len(key.Scope) == 0 {
+		return nil
 	}
+	scopeStr := strings.Join(key.Scope, " ")
+	if env == "" {
+		return fmt.Errorf("key %s is limited to %s, env must be specified", key.Name, scopeStr)
+	}
+	res, err := vals.resolveEnv(env)
+	if err != nil {
+		return err
+	}
+
+	var allowed []string
+	for _, scopeEnv := range key.Scope {
+		if scopeRes, err := vals.resolveEnv(scopeEnv); err == nil {
+			allowed = append(allowed, scopeRes.included...)
+		} else {
+			allowed = append(allowed, scopeEnv) // unknown in this file, but still a valid pattern
+		}
+	}
+	for _, pat := range res.included {
+		if findMatch(allowed, pat) == "" {
+			return fmt.Errorf("key %s is limited to %s, cannot encrypt for %s", key.Name, scopeStr, env)
+		}
+	}
+	return nil
+}
+
+type replacement struct {
+	LHS string
+	RHS string
+}
+
+// encryptVariant returns the lines to replace the variant with. This is
+// normally just the variant itself, but gen: values covering a group
+// expand into a separate line for each env.
+func (vals *Values) encryptVariant(v *Variant, keyring KeyProvider) ([]replacement, error) {
+	var rhs string
+	var err error
+	switch v.Encoding {
+	case ToBeEncryptedFile:
+		rhs, err = vals.EncryptFile(v.Value, v.Env, v.KeyName, keyring)
+	case ToBeGenerated:
+		return vals.generateVariant(v, keyring)
+	default:
+		rhs, err = vals.EncryptValue(v.Value, v.Env, v.KeyName, keyring)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []replacement{{v.RawLHS, rhs}}, nil
+}
+
+func (vals *Values) generateVariant(v *Variant, keyring KeyProvider) ([]replacement, error) {
+	e := vals.findEntry(v.Name, v.RawLHS)
+	envs, err := vals.generationTargets(v.Name, e)
+	if err != nil {
+		return nil, err
+	}
+	if envs == nil {
+		val, err := Generate(v.Value)
+		if err != nil {
+			return nil, err
+		}
+		rhs, err := vals.EncryptValue(val, v.Env, v.KeyName, keyring)
+		if err != nil {
+			return nil, err
+		}
+		return []replacement{{v.RawLHS, rhs}}, nil
+	}
+
+	suffix := lhsSuffix(v.RawLHS)
+	result := make([]replacement, 0, len(envs))
+	for _, env := range envs {
+		val, err := Generate(v.Value)
+		if err != nil {
+			return nil, err
+		}
+		rhs, err := vals.EncryptValue(val, env, v.KeyName, keyring)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", env, err)
+		}
+		result = append(result, replacement{v.Name + "." + env + suffix, rhs})
+	}
+	return result, nil
+}
 
-	ciphertext := secretbox.Seal(nil, []byte(val), &nonce, &key.Data)
+func (vals *Values) findEntry(name, rawLHS string) *entry {
+	for _, e := range vals.entries[name] {
+		if e.RawLHS == rawLHS {
+			return e
+		}
+	}
+	return nil
+}
 
-	return fmt.Sprintf("secret:%s:%s:%s", keyName, base64.StdEncoding.EncodeToString(nonce[:]), base64.StdEncoding.EncodeToString(ciphertext)), nil
+// SetDir sets the directory that file:, encfile: and secretfile: paths are
+// relative to (ParseFile sets it to the directory of the file). Secrets files
+// fetched by ParseSource may only contain such values after a SetDir call, so
+// that whoever controls the source cannot make the app read local files.
+func (vals *Values) SetDir(dir string) {
+	vals.dir = dir
 }
 
-func (vals *Values) EncryptAllInMap(keyring Keyring) (map[string]string, []*Variant) {
+// resolvePath resolves a path relative to the secrets file, refusing
+// absolute paths and paths that escape its directory.
+func (vals *Values) resolvePath(path string) (string, error) {
+	if vals.remote && vals.dir == "" {
+		return "", fmt.Errorf("path %q in a secrets file fetched from a source, call SetDir to allow file values", path)
+	}
+	if !filepath.IsLocal(path) {
+		return "", fmt.Errorf("path %q must be relative to the secrets file and stay within its directory", path)
+	}
+	return filepath.Join(vals.dir, path), nil
+}
+
+// EncryptAllInMap returns the new values of encrypted entries keyed by
+// their LHS. A gen: value covering several envs is replaced by per-env
+// entries, and its own LHS is left out of the result, so a caller applying
+// the result should remove the LHS of every variant that is neither in the
+// result nor failed.
+func (vals *Values) EncryptAllInMap(keyring KeyProvider) (map[string]string, []*Variant) {
 	vars := vals.VariantsToEncrypt()
 	if len(vars) == 0 {
 		return nil, nil
@@ -522,58 +1015,59 @@ func (vals *Values) EncryptAllInMap(keyring Keyring) (map[string]string, []*Vari
 	result := make(map[string]string)
 	var failed []*Variant
 	for _, v := range vars {
-		rhs, err := vals.EncryptValue(v.Value, v.Env, v.KeyName, keyring)
+		repls, err := vals.encryptVariant(v, keyring)
 		if err != nil {
 			v.Err = err
 			failed = append(failed, v)
-		} else {
-			result[v.RawLHS] = rhs
+			continue
+		}
+		for _, r := range repls {
+			result[r.LHS] = r.RHS
 		}
 	}
 	return result, failed
 }
 
-func (vals *Values) EncryptAllInString(data string, keyring Keyring) (string, int, []*Variant) {
+func (vals *Values) EncryptAllInString(data string, keyring KeyProvider) (string, int, []*Variant) {
 	vars := vals.VariantsToEncrypt()
 	if len(vars) == 0 {
 		return data, 0, nil
 	}
 
-	var regexps []*regexp.Regexp
-	var replacements []string
-	var failed []*Variant
+	pairs, _ := parseKVPairs(data) // on error, nothing matches, just like with mismatched data
+	pairsByKey := make(map[string]*kvPair, len(pairs))
+	for _, p := range pairs {
+		pairsByKey[p.Key] = p
+	}
 
+	lines := strings.Split(data, "\n")
+	var edits []lineEdit
+	var failed []*Variant
 	for _, v := range vars {
-		rhs, err := vals.EncryptValue(v.Value, v.Env, v.KeyName, keyring)
+		p := pairsByKey[v.RawLHS]
+		if p == nil || p.Value != v.RawRHS {
+			continue
+		}
+
+		repls, err := vals.encryptVariant(v, keyring)
 		if err != nil {
 			v.Err = err
 			failed = append(failed, v)
 			continue
 		}
-
-		lhs := regexp.QuoteMeta(v.RawLHS)
-		oldRHS := regexp.QuoteMeta(v.RawRHS)
-		pat := regexp.MustCompile(`^(\s*` + lhs + `\s*=\s*)` + oldRHS + `\s*$`)
-		regexps = append(regexps, pat)
-		replacements = append(replacements, rhs)
-	}
-
-	lines := strings.Split(data, "\n")
-	var modified int
-	for li, line := range lines {
-		for ri, re := range regexps {
-			m := re.FindStringSubmatch(line)
-			if m != nil {
-				lines[li] = m[1] + replacements[ri]
-				modified++
-				break
-			}
+		prefix := lhsPrefix(lines[p.Line-1])
+		newLines := make([]string, 0, len(repls))
+		for _, r := range repls {
+			newLines = append(newLines, strings.Replace(prefix, v.RawLHS, r.LHS, 1)+r.RHS)
 		}
+		edits = append(edits, lineEdit{p.Line, p.EndLine, newLines})
 	}
-	return strings.Join(lines, "\n"), modified, failed
+
+	lines = applyLineEdits(lines, edits)
+	return strings.Join(lines, "\n"), len(edits), failed
 }
 
-func (vals *Values) EncryptAllInFile(path string, keyring Keyring) (int, []*Variant, error) {
+func (vals *Values) EncryptAllInFile(path string, keyring KeyProvider) (int, []*Variant, error) {
 	s, err := os.Stat(path)
 	if err != nil {
 		return 0, nil, err
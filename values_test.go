@@ -1,10 +1,15 @@
 package plainsecrets
 
 import (
+	"crypto/sha256"
 	_ "embed"
+	"encoding/base64"
+	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 	"testing"
+	"time"
 )
 
 //go:embed testdata/secrets.txt
@@ -47,6 +52,8 @@ func TestValues(t *testing.T) {
 
 		{"explicit", "@all=foo bar | TEST.foo=42 | TEST.bar=10", "TEST.bar=10 | TEST.foo=42"},
 		{"override", "@all=prod nonprod | @nonprod = dev stag | TEST=42 | TEST.nonprod=10", "TEST.dev=10 | TEST.prod=42 | TEST.stag=10"},
+		{"quoted", `@all=foo | A=" x\ty " | B="NONE" | C='secret:x\n' | D=""`, `A.foo= x	y  | B.foo=NONE | C.foo=secret:x\n`},
+		{"bad quotes", `@all=foo | A="x`, `ERR: malformed quoted string "x in "A=\"x"`},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -81,6 +88,44 @@ func TestEncryption(t *testing.T) {
 	}
 }
 
+func TestMultiline(t *testing.T) {
+	keyring := must(ParseKeyringString(sampleKeyring))
+
+	input := "@all = foo\nCERT = <<EOF\n-----BEGIN-----\n  abc\n-----END-----\nEOF\nKEY = enc:myapp-dev:<<END\nline1\n\nline3\nEND\nX = 1\n"
+	vals := must(ParseString(input))
+	if a, e := tostr3(vals.Value("CERT", "foo", keyring)), "-----BEGIN-----\n  abc\n-----END-----"; a != e {
+		t.Errorf("** CERT = %q, wanted %q", a, e)
+	}
+
+	output, n, failed := vals.EncryptAllInString(input, keyring)
+	if n != 1 || len(failed) != 0 {
+		t.Fatalf("** EncryptAllInString = %d, %v", n, failed)
+	}
+	if a, e := strings.Count(output, "\n"), 8; a != e {
+		t.Errorf("** got %d lines, wanted %d:\n%s", a, e, output)
+	}
+	vals = must(ParseString(output))
+	if a, e := tostr3(vals.Value("KEY", "foo", keyring)), "line1\n\nline3"; a != e {
+		t.Errorf("** KEY = %q, wanted %q", a, e)
+	}
+	if a, e := tostr3(vals.Value("X", "foo", keyring)), "1"; a != e {
+		t.Errorf("** X = %q, wanted %q", a, e)
+	}
+
+	// only after = or enc:<keyname>:, other values ending in <<WORD are literal
+	vals = must(ParseString("@all = foo\nX = foo:<<EOF\nY = bar:baz:<<END\nZ = 1\n"))
+	for _, c := range []struct{ name, value string }{{"X", "foo:<<EOF"}, {"Y", "bar:baz:<<END"}, {"Z", "1"}} {
+		if a, e := tostr3(vals.Value(c.name, "foo", keyring)), c.value; a != e {
+			t.Errorf("** %s = %q, wanted %q", c.name, a, e)
+		}
+	}
+
+	_, err := ParseString("@all = foo\nCERT = <<EOF\nabc\n")
+	if a, e := tostr3("", err), "ERR: line 2: unterminated heredoc, missing EOF"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+}
+
 func TestResolve(t *testing.T) {
 	keyring := must(ParseKeyringString(sampleKeyring))
 
@@ -150,7 +195,7 @@ func tostr2(vals *Values, err error, keyring Keyring) string {
 
 		var lines []string
 		for _, env := range envs {
-			m, err := vals.EnvValues(env, keyring)
+			m, err := vals.allEnvValues(env, keyring)
 			if err != nil {
 				return "ERR: " + err.Error()
 			}
@@ -172,3 +217,208 @@ func tostr3(val string, err error) string {
 		return val
 	}
 }
+
+func TestFileValues(t *testing.T) {
+	keyring := must(ParseKeyringString(sampleKeyring))
+
+	dir := t.TempDir()
+	secretsFile := filepath.Join(dir, "secrets.txt")
+	os.WriteFile(secretsFile, []byte("@all = foo\nA = file:a.txt\nB = encfile:myapp-dev:b.json\n"), 0600)
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello\n"), 0600)
+	os.WriteFile(filepath.Join(dir, "b.json"), []byte(`{"b": 42}`), 0600)
+
+	m, err := LoadFileValues(secretsFile, "foo", keyring, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, e := m["A"], "hello\n"; a != e {
+		t.Errorf("** A = %q, wanted %q", a, e)
+	}
+	if a, e := m["B"], `{"b": 42}`; a != e {
+		t.Errorf("** B = %q, wanted %q", a, e)
+	}
+
+	raw := string(must(os.ReadFile(secretsFile)))
+	if !strings.Contains(raw, "B = secretfile:myapp-dev:b.json.enc:") {
+		t.Errorf("** B not encrypted:\n%s", raw)
+	}
+	m, err = LoadFileValues(secretsFile, "foo", keyring, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, e := m["B"], `{"b": 42}`; a != e {
+		t.Errorf("** B = %q, wanted %q", a, e)
+	}
+
+	encFile := filepath.Join(dir, "b.json.enc")
+	enc := must(os.ReadFile(encFile))
+	enc[len(enc)-1] ^= 1
+	os.WriteFile(encFile, enc, 0600)
+	_, err = LoadFileValues(secretsFile, "foo", keyring, false)
+	if a, e := tostr3("", err), "ERR: B: digest mismatch for "+encFile; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+}
+
+func TestFilePaths(t *testing.T) {
+	keyring := must(ParseKeyringString(sampleKeyring))
+	digest := base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+
+	tests := []struct {
+		rhs  string
+		path string
+		err  string
+	}{
+		{"file:a.txt", "a.txt", ""},
+		{"file:sub/../a.txt", "a.txt", ""},
+		{"file:/etc/passwd", "", `path "/etc/passwd" must be relative to the secrets file and stay within its directory`},
+		{"file:../a.txt", "", `path "../a.txt" must be relative to the secrets file and stay within its directory`},
+		{"file:sub/../../a.txt", "", `path "sub/../../a.txt" must be relative to the secrets file and stay within its directory`},
+		{"encfile:myapp-dev:../a.txt", "", `path "../a.txt" must be relative to the secrets file and stay within its directory`},
+		{"secretfile:myapp-dev:/tmp/a.enc:" + digest, "", `path "/tmp/a.enc" must be relative to the secrets file and stay within its directory`},
+		{"secretfile:myapp-dev:a:b.enc:" + digest, "a:b.enc", ""},
+		{"secretfile:v2:aes-256-gcm:myapp-dev:a:b.enc:" + digest, "a:b.enc", ""},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		secretsFile := filepath.Join(dir, "secrets.txt")
+		os.WriteFile(secretsFile, []byte("@all = dev\nA = "+tt.rhs+"\n"), 0600)
+		vals := New()
+		err := vals.ParseFile(secretsFile)
+		if tt.err != "" {
+			if a, e := tostr3("", err), "ERR: "+secretsFile+": "+tt.err+" in \"A="+tt.rhs+"\""; a != e {
+				t.Errorf("** %s: got %q, wanted %q", tt.rhs, a, e)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("** %s: %v", tt.rhs, err)
+			continue
+		}
+		if a, e := vals.entries["A"][0].Path, filepath.Join(dir, tt.path); a != e {
+			t.Errorf("** %s: path = %q, wanted %q", tt.rhs, a, e)
+		}
+	}
+
+	// a file with a colon in its name can be encrypted and read back
+	dir := t.TempDir()
+	secretsFile := filepath.Join(dir, "secrets.txt")
+	os.WriteFile(secretsFile, []byte("@all = dev\nA = encfile:myapp-dev:a:b.txt\n"), 0600)
+	os.WriteFile(filepath.Join(dir, "a:b.txt"), []byte("hello"), 0600)
+	must(LoadFileValues(secretsFile, "dev", keyring, true))
+	if a, e := must(LoadFileValues(secretsFile, "dev", keyring, false))["A"], "hello"; a != e {
+		t.Errorf("** A = %q, wanted %q", a, e)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	keyring := must(ParseKeyringString(sampleKeyring))
+
+	input := "@all = prod stag dev-*\n@dev = dev-*\nDEFAULT_KEY = myapp-dev\nDEFAULT_KEY.prod = myapp-prod\nS = gen:hex:16\nP.prod = gen:password:20:digits\nP.nonprod = x\n@nonprod = ! prod\n"
+	vals := must(ParseString(input))
+	output, n, failed := vals.EncryptAllInString(input, keyring)
+	if n != 2 || len(failed) != 0 {
+		t.Fatalf("** EncryptAllInString = %d, %v", n, failed)
+	}
+	for _, prefix := range []string{"S.prod = secret:myapp-prod:", "S.stag = secret:myapp-dev:", "S.dev = secret:myapp-dev:", "P.prod = secret:myapp-prod:"} {
+		if !strings.Contains(output, "\n"+prefix) {
+			t.Errorf("** missing %s in:\n%s", prefix, output)
+		}
+	}
+
+	vals = must(ParseString(output))
+	seen := make(map[string]bool)
+	for _, env := range []string{"prod", "stag", "dev-john"} {
+		s := tostr3(vals.Value("S", env, keyring))
+		if len(s) != 32 || seen[s] {
+			t.Errorf("** S.%s = %q", env, s)
+		}
+		seen[s] = true
+	}
+	if p := tostr3(vals.Value("P", "prod", keyring)); len(p) != 20 || strings.Trim(p, "0123456789") != "" {
+		t.Errorf("** P.prod = %q", p)
+	}
+
+	// a scheduled group entry still gets distinct values per env
+	input = "@all = prod stag\nDEFAULT_KEY = myapp-dev\nS = old\nS@2099-01-01 = gen:hex:16\n"
+	vals = must(ParseString(input))
+	m, failed := vals.EncryptAllInMap(keyring)
+	if _, found := m["S@2099-01-01"]; len(m) != 2 || found || len(failed) != 0 {
+		t.Fatalf("** EncryptAllInMap = %v, %v", m, failed)
+	}
+	if m["S.prod@2099-01-01"] == "" || m["S.stag@2099-01-01"] == "" {
+		t.Errorf("** missing per-env values in %v", m)
+	}
+	output, _, _ = vals.EncryptAllInString(input, keyring)
+	vals = must(ParseString(output))
+	vals.Clock = func() time.Time { return time.Date(2099, 6, 1, 0, 0, 0, 0, time.UTC) }
+	if p, s := tostr3(vals.Value("S", "prod", keyring)), tostr3(vals.Value("S", "stag", keyring)); len(p) != 32 || p == s {
+		t.Errorf("** S.prod = %q, S.stag = %q", p, s)
+	}
+
+	_, err := ParseString("@all = foo\nS = gen:password:10:emoji")
+	if a, e := tostr3("", err), `ERR: unknown charset "emoji" in gen:password:10:emoji, expected one of alnum, alpha, digits, symbols in "S=gen:password:10:emoji"`; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+}
+
+func TestScheduled(t *testing.T) {
+	input := "@all = prod stag | K = old | K@2026-01-01 = mid | K@2026-06-01T00:00Z = new | K.prod@2027-01-01 = future | K.stag@2025-01-01 = stag"
+	tests := []struct {
+		now      string
+		expected string
+		upcoming string
+		lint     string
+	}{
+		{"2025-12-31T23:59:59Z", "K.prod=old | K.stag=stag", "K@2026-01-01 K@2026-06-01T00:00Z K.prod@2027-01-01", "K.stag@2025-01-01: activation time has passed, the @ suffix can be removed"},
+		{"2026-03-01T00:00:00Z", "K.prod=mid | K.stag=stag", "K@2026-06-01T00:00Z K.prod@2027-01-01", "K: superseded by K@2026-01-01 since 2026-01-01T00:00:00Z, can be removed | K.stag@2025-01-01: activation time has passed, the @ suffix can be removed"},
+		{"2027-01-01T00:00:00Z", "K.prod=future | K.stag=stag", "", "K: superseded by K@2026-06-01T00:00Z since 2026-06-01T00:00:00Z, can be removed | K.prod@2027-01-01: activation time has passed, the @ suffix can be removed | K.stag@2025-01-01: activation time has passed, the @ suffix can be removed | K@2026-01-01: superseded by K@2026-06-01T00:00Z since 2026-06-01T00:00:00Z, can be removed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.now, func(t *testing.T) {
+			now := must(time.Parse(time.RFC3339, tt.now))
+			vals := New()
+			vals.Clock = func() time.Time { return now }
+			err := vals.ParseString(strings.ReplaceAll(input, "|", "\n"))
+			if a := tostr2(vals, err, nil); a != tt.expected {
+				t.Errorf("** values = %q, expected %q", a, tt.expected)
+			}
+			var upcoming, lint []string
+			for _, v := range vals.Upcoming() {
+				upcoming = append(upcoming, v.RawLHS)
+			}
+			for _, v := range vals.Lint() {
+				lint = append(lint, v.RawLHS+": "+v.Err.Error())
+			}
+			if a := strings.Join(upcoming, " "); a != tt.upcoming {
+				t.Errorf("** upcoming = %q, expected %q", a, tt.upcoming)
+			}
+			if a := strings.Join(lint, " | "); a != tt.lint {
+				t.Errorf("** lint = %q, expected %q", a, tt.lint)
+			}
+		})
+	}
+}
+
+func TestScheduledOnly(t *testing.T) {
+	input := "@all = prod | N@2027-01-01 = later | K@2025-01-01 = a | K@2025-01-01T00:00Z = b | K@2026-01-01 = c"
+	tests := []struct {
+		now      string
+		expected string
+	}{
+		{"2025-06-01T00:00:00Z", "ERR: conflicting values with match length 4 for K.all and K.all when resolving for .prod"},
+		{"2026-06-01T00:00:00Z", "K.prod=c"},
+		{"2027-06-01T00:00:00Z", "K.prod=c | N.prod=later"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.now, func(t *testing.T) {
+			now := must(time.Parse(time.RFC3339, tt.now))
+			vals := New()
+			vals.Clock = func() time.Time { return now }
+			err := vals.ParseString(strings.ReplaceAll(input, "|", "\n"))
+			if a := tostr2(vals, err, nil); a != tt.expected {
+				t.Errorf("** values = %q, expected %q", a, tt.expected)
+			}
+		})
+	}
+}
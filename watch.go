@@ -0,0 +1,300 @@
+package plainsecrets
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultWatchInterval is how often Watcher polls files by default.
+const DefaultWatchInterval = 2 * time.Second
+
+// inotifyPollFactor slows down polling when inotify is used, which makes
+// polling a fallback.
+const inotifyPollFactor = 30
+
+// Watcher keeps a secrets file (and optionally a keyring file) loaded,
+// reloading it when it changes on disk. A new version is only swapped in
+// after every env in use (queried or subscribed to) has been fully
+// decrypted without errors; otherwise the last good version stays in use and
+// the error is reported to OnError.
+//
+// On Linux, changes are picked up via inotify, elsewhere (or with Poll set)
+// the files are polled every Interval. Replacing the files by renaming a new
+// version over them works with both, and so does swapping a symlink they
+// resolve through, like Kubernetes does for mounted secrets. With inotify,
+// the files are still polled, 30 times less often, in case a change goes
+// unnoticed, e.g. an in-place edit of a file that a swapped
+// symlink has started pointing to.
+type Watcher struct {
+	// Interval is how often to poll the files, DefaultWatchInterval if zero.
+	Interval time.Duration
+
+	// Poll makes the watcher poll even if inotify is available.
+	Poll bool
+
+	// OnError is called when reloading fails.
+	OnError func(err error)
+
+	// VerifyKey makes the watcher only accept files signed with this key,
+	// with a serial no lower than that of the version in use. See SignFile.
+	VerifyKey *Key
+
+	path        string
+	keyringPath string
+	keys        KeyProvider
+
+	reloadMu  sync.Mutex
+	mu        sync.Mutex
+	vals      *Values
+	keyring   Keyring // loaded from keyringPath
+	envValues map[string]map[string]string
+	subs      []*subscription
+	stamps    map[string]fileStamp
+}
+
+type subscription struct {
+	env string
+	f   func(changed []string)
+}
+
+type fileStamp struct {
+	info os.FileInfo
+}
+
+func (s fileStamp) same(info os.FileInfo) bool {
+	return s.info != nil && info != nil && os.SameFile(s.info, info) && s.info.ModTime().Equal(info.ModTime()) && s.info.Size() == info.Size()
+}
+
+// NewWatcher loads the secrets file at path. If keyringPath is not empty,
+// the keyring is loaded from that file (and reloaded with changes), and used
+// in addition to keys, which may be nil. Call Run to start watching.
+func NewWatcher(path, keyringPath string, keys KeyProvider) (*Watcher, error) {
+	w := &Watcher{
+		path:        path,
+		keyringPath: keyringPath,
+		keys:        keys,
+		envValues:   make(map[string]map[string]string),
+		stamps:      make(map[string]fileStamp),
+	}
+	w.updateStamps()
+	if err := w.Reload(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *Watcher) files() []string {
+	if w.keyringPath != "" {
+		return []string{w.path, w.keyringPath}
+	}
+	return []string{w.path}
+}
+
+// updateStamps records the current state of the files, returning whether
+// any of them changed.
+func (w *Watcher) updateStamps() bool {
+	var changed bool
+	for _, path := range w.files() {
+		info, _ := os.Stat(path)
+		if !w.stamps[path].same(info) {
+			w.stamps[path] = fileStamp{info}
+			changed = true
+		}
+	}
+	return changed
+}
+
+// Run watches the files until the context is done.
+func (w *Watcher) Run(ctx context.Context) error {
+	interval := w.Interval
+	if interval == 0 {
+		interval = DefaultWatchInterval
+	}
+
+	var events <-chan struct{}
+	if !w.Poll {
+		ch, stop, err := watchFiles(w.files())
+		if err == nil && ch != nil {
+			defer stop()
+			events = ch
+		}
+	}
+	if events != nil {
+		interval *= inotifyPollFactor
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	tick := ticker.C
+	// pick up changes made before the files were being watched
+	w.reloadIfChanged()
+
+	// editors and deploy scripts often write in several steps
+	const settleDelay = 50 * time.Millisecond
+	var settle *time.Timer
+	var settled <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-events:
+			if settle == nil {
+				settle = time.NewTimer(settleDelay)
+			} else {
+				settle.Reset(settleDelay)
+			}
+			settled = settle.C
+			continue
+		case <-settled:
+			settled = nil
+		case <-tick:
+		}
+		w.reloadIfChanged()
+	}
+}
+
+func (w *Watcher) reloadIfChanged() {
+	if w.updateStamps() {
+		if err := w.Reload(); err != nil && w.OnError != nil {
+			w.OnError(err)
+		}
+	}
+}
+
+// Subscribe calls f with the sorted names of the values that changed for
+// the env after each successful reload (if any did). Values that disappeared
+// count as changed. Returns an error if the env cannot be loaded now.
+func (w *Watcher) Subscribe(env string, f func(changed []string)) error {
+	if _, err := w.EnvValues(env); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.subs = append(w.subs, &subscription{env, f})
+	return nil
+}
+
+// EnvValues returns the values of the env from the current version, all of
+// which get decrypted when the version is loaded.
+func (w *Watcher) EnvValues(env string) (map[string]string, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	m := w.envValues[env]
+	if m == nil {
+		var err error
+		m, err = w.vals.allEnvValues(env, w.currentKeys(w.keyring))
+		if err != nil {
+			return nil, err
+		}
+		w.envValues[env] = m
+	}
+	result := make(map[string]string, len(m))
+	for k, v := range m {
+		result[k] = v
+	}
+	return result, nil
+}
+
+// Value returns a value of the env from the current version.
+func (w *Watcher) Value(name, env string) (string, error) {
+	m, err := w.EnvValues(env)
+	return m[name], err
+}
+
+// Serial returns the serial of the current version, see Values.Serial.
+func (w *Watcher) Serial() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.vals.Serial()
+}
+
+func (w *Watcher) currentKeys(keyring Keyring) KeyProvider {
+	if w.keyringPath == "" {
+		return w.keys
+	} else if w.keys == nil {
+		return keyring
+	}
+	return KeyChain{keyring, w.keys}
+}
+
+// Reload loads the files now, regardless of whether they have changed, and
+// swaps in the new version if it is valid.
+func (w *Watcher) Reload() error {
+	w.reloadMu.Lock()
+	defer w.reloadMu.Unlock()
+
+	var keyring Keyring
+	if w.keyringPath != "" {
+		var err error
+		keyring, err = ParseKeyringFile(w.keyringPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	w.mu.Lock()
+	envs := make([]string, 0, len(w.envValues))
+	for env := range w.envValues {
+		envs = append(envs, env)
+	}
+	minSerial := 0
+	if w.vals != nil {
+		minSerial = w.vals.Serial()
+	}
+	w.mu.Unlock()
+
+	vals := New()
+	vals.VerifyKey = w.VerifyKey
+	vals.MinSerial = minSerial
+	if err := vals.ParseFile(w.path); err != nil {
+		keyring.Close()
+		return err
+	}
+	sort.Strings(envs)
+	envValues := make(map[string]map[string]string, len(envs))
+	for _, env := range envs {
+		m, err := vals.allEnvValues(env, w.currentKeys(keyring))
+		if err != nil {
+			keyring.Close()
+			return fmt.Errorf("%s: env %s: %w", w.path, env, err)
+		}
+		envValues[env] = m
+	}
+
+	w.mu.Lock()
+	oldKeyring, oldEnvValues := w.keyring, w.envValues
+	w.vals, w.keyring, w.envValues = vals, keyring, envValues
+	subs := w.subs
+	w.mu.Unlock()
+	oldKeyring.Close()
+
+	for _, sub := range subs {
+		newValues, ok := envValues[sub.env]
+		if !ok {
+			continue // subscribed during the reload
+		}
+		if changed := changedNames(oldEnvValues[sub.env], newValues); len(changed) > 0 {
+			sub.f(changed)
+		}
+	}
+	return nil
+}
+
+func changedNames(oldValues, newValues map[string]string) []string {
+	var changed []string
+	for name, val := range newValues {
+		if oldVal, ok := oldValues[name]; !ok || oldVal != val {
+			changed = append(changed, name)
+		}
+	}
+	for name := range oldValues {
+		if _, ok := newValues[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
@@ -0,0 +1,120 @@
+//go:build linux
+
+package plainsecrets
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// watchFiles uses inotify to report changes to the files. It watches the
+// directories rather than the files, so that files replaced via rename are
+// picked up, too. Symlinks the paths resolve through are watched as well,
+// so that swapping one (like Kubernetes does with ..data for mounted
+// secrets) is picked up.
+func watchFiles(paths []string) (<-chan struct{}, func(), error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, nil, err
+	}
+	// a non-blocking fd makes reads go through the runtime poller, so
+	// that closing the file interrupts a pending read
+	f := os.NewFile(uintptr(fd), "inotify")
+
+	names := make(map[string]bool)
+	dirs := make(map[string]bool) // true for the dirs of the paths themselves
+	for _, path := range paths {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		dirs[filepath.Dir(abs)] = true
+		for _, p := range symlinkChain(abs) {
+			names[p] = true
+			if _, ok := dirs[filepath.Dir(p)]; !ok {
+				dirs[filepath.Dir(p)] = false
+			}
+		}
+	}
+	wds := make(map[int32]string)
+	for dir, required := range dirs {
+		wd, err := syscall.InotifyAddWatch(fd, dir, syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO|syscall.IN_CREATE|syscall.IN_DELETE|syscall.IN_ATTRIB)
+		if err != nil {
+			if !required {
+				continue // dangling symlink, nothing to watch there yet
+			}
+			f.Close()
+			return nil, nil, err
+		}
+		wds[int32(wd)] = dir
+	}
+
+	events := make(chan struct{}, 1)
+	go func() {
+		buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				return
+			}
+			for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+				ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+				nameBytes := buf[off+syscall.SizeofInotifyEvent : off+syscall.SizeofInotifyEvent+int(ev.Len)]
+				off += syscall.SizeofInotifyEvent + int(ev.Len)
+
+				name := string(nameBytes)
+				for len(name) > 0 && name[len(name)-1] == 0 {
+					name = name[:len(name)-1]
+				}
+				if names[filepath.Join(wds[ev.Wd], name)] {
+					select {
+					case events <- struct{}{}:
+					default:
+					}
+				}
+			}
+		}
+	}()
+	return events, func() { f.Close() }, nil
+}
+
+// symlinkChain returns the absolute path, the symlinks it resolves through
+// (including the ones in the directory part) and the paths in between,
+// ending with the fully resolved path.
+func symlinkChain(path string) []string {
+	result := []string{path}
+	for n := 0; n < 40; n++ { // like the kernel's limit on nested symlinks
+		link, rest := firstSymlink(path)
+		if link == "" {
+			break
+		}
+		target, err := os.Readlink(link)
+		if err != nil {
+			break
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(link), target)
+		}
+		path = filepath.Join(target, rest)
+		result = append(result, link, path)
+	}
+	return result
+}
+
+// firstSymlink returns the first prefix of the clean absolute path that is
+// a symlink, and the rest of the path after it.
+func firstSymlink(path string) (string, string) {
+	sep := string(filepath.Separator)
+	comps := strings.Split(path, sep)
+	for i := 2; i <= len(comps); i++ {
+		prefix := strings.Join(comps[:i], sep)
+		if fi, err := os.Lstat(prefix); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			return prefix, strings.Join(comps[i:], sep)
+		}
+	}
+	return "", ""
+}
@@ -0,0 +1,58 @@
+//go:build linux
+
+package plainsecrets
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestWatcherSymlinkSwap mimics how Kubernetes updates mounted secrets:
+// secrets.txt -> ..data/secrets.txt, with the ..data symlink swapped for
+// one pointing to a new directory.
+func TestWatcherSymlinkSwap(t *testing.T) {
+	dir := t.TempDir()
+	version := func(name, data string) {
+		if err := os.Mkdir(filepath.Join(dir, name), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name, "secrets.txt"), []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(name, filepath.Join(dir, "..data_tmp")); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	version("..v1", "@all = prod\nURL = http://a\n")
+	path := filepath.Join(dir, "secrets.txt")
+	if err := os.Symlink("..data/secrets.txt", path); err != nil {
+		t.Fatal(err)
+	}
+
+	w := must(NewWatcher(path, "", nil))
+	w.Interval = time.Hour // make sure inotify does the job
+	changes := make(chan struct{}, 10)
+	if err := w.Subscribe("prod", func(changed []string) { changes <- struct{}{} }); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx)
+	time.Sleep(50 * time.Millisecond) // let Run set up the watches
+
+	version("..v2", "@all = prod\nURL = http://b\n")
+	select {
+	case <-changes:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("** no change notification")
+	}
+	if a, e := must(w.Value("URL", "prod")), "http://b"; a != e {
+		t.Errorf("** got %q, wanted %q", a, e)
+	}
+}
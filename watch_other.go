@@ -0,0 +1,8 @@
+//go:build !linux
+
+package plainsecrets
+
+// watchFiles is not supported here, the watcher polls instead.
+func watchFiles(paths []string) (<-chan struct{}, func(), error) {
+	return nil, nil, nil
+}
@@ -0,0 +1,84 @@
+package plainsecrets
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatcher(t *testing.T) {
+	keyring := must(ParseKeyringString(sampleKeyring))
+	enc := func(s string) string {
+		return must(New().EncryptValue(s, "prod", "myapp-prod", keyring))
+	}
+
+	for _, poll := range []bool{true, false} {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "secrets.txt")
+		keyringPath := filepath.Join(dir, "keyring.txt")
+		replace := func(path, data string) {
+			tmp := path + ".tmp"
+			if err := os.WriteFile(tmp, []byte(data), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Rename(tmp, path); err != nil {
+				t.Fatal(err)
+			}
+		}
+		replace(keyringPath, sampleKeyring)
+		replace(path, "@all = prod\nURL = http://a\nTOKEN = "+enc("one")+"\nOTHER = x\n")
+
+		w := must(NewWatcher(path, keyringPath, nil))
+		w.Poll = poll
+		w.Interval = 10 * time.Millisecond
+		if !poll && runtime.GOOS == "linux" {
+			w.Interval = time.Hour // make sure inotify does the job
+		}
+		errs := make(chan error, 10)
+		w.OnError = func(err error) { errs <- err }
+		changes := make(chan string, 10)
+		if err := w.Subscribe("prod", func(changed []string) { changes <- strings.Join(changed, " ") }); err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go w.Run(ctx)
+
+		replace(path, "@all = prod\nURL = http://a\nTOKEN = "+enc("two")+"\nNEW = y\n")
+		select {
+		case a := <-changes:
+			if e := "NEW OTHER TOKEN"; a != e {
+				t.Errorf("** poll=%v: got %q, wanted %q", poll, a, e)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("** poll=%v: no change notification", poll)
+		}
+		if a, e := must(w.Value("TOKEN", "prod")), "two"; a != e {
+			t.Errorf("** poll=%v: got %q, wanted %q", poll, a, e)
+		}
+
+		// a value that cannot be decrypted keeps the last good version
+		replace(keyringPath, "myapp-prod=5OnO+jqOo/hhz1DVJox3TpaefmbwFqbiw6HYfuogz+Y=\n")
+		select {
+		case err := <-errs:
+			if !strings.Contains(err.Error(), "TOKEN") {
+				t.Errorf("** poll=%v: got %v", poll, err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("** poll=%v: no error", poll)
+		}
+		if a, e := must(w.Value("TOKEN", "prod")), "two"; a != e {
+			t.Errorf("** poll=%v: got %q, wanted %q", poll, a, e)
+		}
+		select {
+		case a := <-changes:
+			t.Errorf("** poll=%v: unexpected change %q", poll, a)
+		default:
+		}
+		cancel()
+	}
+}